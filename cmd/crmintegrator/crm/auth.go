@@ -0,0 +1,181 @@
+package crm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/josesolana/csv-reader/config"
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// Auth Adds credentials to CRM requests.
+type Auth interface {
+	Apply(ctx context.Context, req *http.Request) error
+	// Invalidate Forget cached credentials after req has been rejected,
+	// e.g. by a 401, unless they have been renewed meanwhile.
+	Invalidate(req *http.Request)
+}
+
+// NewAuth Auth set up from the crm_auth settings. nil if there is none.
+func NewAuth(cfg *config.Config) (Auth, error) {
+	switch cfg.CRMAuth {
+	case "":
+		return nil, nil
+	case c.AuthBearer:
+		return &StaticAuth{Header: "Authorization", Value: "Bearer " + cfg.CRMAuthToken}, nil
+	case c.AuthAPIKey:
+		return &StaticAuth{Header: cfg.CRMAuthHeader, Value: cfg.CRMAuthToken}, nil
+	case c.AuthBasic:
+		return &BasicAuth{User: cfg.CRMAuthUser, Password: cfg.CRMAuthPassword}, nil
+	case c.AuthOAuth2:
+		return &OAuth2{
+			TokenURL:     cfg.CRMOAuthTokenURL,
+			ClientID:     cfg.CRMOAuthClientID,
+			ClientSecret: cfg.CRMOAuthClientSecret,
+			Scope:        cfg.CRMOAuthScope,
+			HTTP:         &http.Client{Timeout: cfg.TimeOut},
+		}, nil
+	}
+	return nil, errors.New(c.ErrCRMAuthUnknown)
+}
+
+// StaticAuth A header with a fixed value, e.g. a bearer token or an API key.
+type StaticAuth struct {
+	Header string
+	Value  string
+}
+
+// Apply Set the header.
+func (a *StaticAuth) Apply(ctx context.Context, req *http.Request) error {
+	req.Header.Set(a.Header, a.Value)
+	return nil
+}
+
+// Invalidate Nothing to forget.
+func (a *StaticAuth) Invalidate(req *http.Request) {}
+
+// BasicAuth Http basic authentication.
+type BasicAuth struct {
+	User     string
+	Password string
+}
+
+// Apply Set the Authorization header.
+func (a *BasicAuth) Apply(ctx context.Context, req *http.Request) error {
+	req.SetBasicAuth(a.User, a.Password)
+	return nil
+}
+
+// Invalidate Nothing to forget.
+func (a *BasicAuth) Invalidate(req *http.Request) {}
+
+// OAuth2 Client credentials grant. The token is cached & fetched again
+// c.TokenRefresh before it expires, or after Invalidate.
+type OAuth2 struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	// Scope Space separated scopes. Empty to not ask for any.
+	Scope string
+	HTTP  *http.Client
+	// Now Current time. time.Now if nil.
+	Now func() time.Time
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// Apply Set a bearer token, fetching a new one if needed.
+func (a *OAuth2) Apply(ctx context.Context, req *http.Request) error {
+	token, err := a.Token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Invalidate Forget the cached token, if req was sent with it. Workers
+// rejected at once fetch a single new token.
+func (a *OAuth2) Invalidate(req *http.Request) {
+	a.mu.Lock()
+	if a.token != "" && req.Header.Get("Authorization") == "Bearer "+a.token {
+		a.token = ""
+	}
+	a.mu.Unlock()
+}
+
+// Token The cached token, or a new one. Workers wait for each other, so
+// only one token is fetched at once.
+func (a *OAuth2) Token(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	if a.token != "" && (a.expiry.IsZero() || now.Add(c.TokenRefresh).Before(a.expiry)) {
+		return a.token, nil
+	}
+
+	token, expiresIn, err := a.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	a.token, a.expiry = token, time.Time{}
+	if expiresIn > 0 {
+		a.expiry = now.Add(time.Duration(expiresIn) * time.Second)
+	}
+	return a.token, nil
+}
+
+func (a *OAuth2) fetch(ctx context.Context) (string, int64, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if a.Scope != "" {
+		form.Set("scope", a.Scope)
+	}
+	req, err := http.NewRequest(http.MethodPost, a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+
+	resp, err := a.HTTP.Do(req.WithContext(ctx))
+	if err != nil {
+		log.Printf("Cannot get a CRM access token. Error: %s\n", err)
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Cannot get a CRM access token. Status: %d. Response: %s\n", resp.StatusCode, body)
+		return "", 0, fmt.Errorf("%s: %d %s", c.ErrCRMAuthToken, resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &t); err != nil || t.AccessToken == "" {
+		return "", 0, errors.New(c.ErrCRMAuthToken)
+	}
+	return t.AccessToken, t.ExpiresIn, nil
+}
+
+func (a *OAuth2) now() time.Time {
+	if a.Now != nil {
+		return a.Now()
+	}
+	return time.Now()
+}
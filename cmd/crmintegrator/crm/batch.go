@@ -0,0 +1,148 @@
+package crm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/josesolana/csv-reader/config"
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// Batch Bulk requests: rows are sent at once as a JSON array and the
+// response holds a result for every one of them.
+type Batch struct {
+	// Results Path to the results array into the response, e.g.
+	// data.results. Empty if the response is the array.
+	Results string
+	// ID Path to the row id or idempotency key into every result.
+	// Empty to match results by their position.
+	ID string
+	// Status Path to the Http status into every result. Empty to use
+	// the response status.
+	Status string
+}
+
+// Result What the CRM answered for a row of a batch.
+type Result struct {
+	Outcome Outcome
+	Status  int
+	Error   string
+	// Body The row's result as JSON.
+	Body []byte
+}
+
+// NewBatch Batch set up from the crm settings.
+func NewBatch(cfg *config.Config) *Batch {
+	return &Batch{
+		Results: cfg.CRMBatchResults,
+		ID:      cfg.CRMBatchID,
+		Status:  cfg.CRMBatchStatus,
+	}
+}
+
+// Request One request with the body of every record into a JSON array.
+func (b *Batch) Request(records []Request) (Request, error) {
+	items := make([]json.RawMessage, len(records))
+	for i, r := range records {
+		items[i] = r.Body
+	}
+	body, err := json.Marshal(items)
+	if err != nil {
+		return Request{}, err
+	}
+	return Request{ID: records[0].ID, Body: body}, nil
+}
+
+// Split Result of every record, in the order they were sent. A record
+// without result is retryable.
+func (b *Batch) Split(resp *Response, records []Request) ([]Result, error) {
+	var doc interface{}
+	d := json.NewDecoder(bytes.NewReader(resp.Body))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+	v, _ := lookup(doc, b.Results)
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New(c.ErrCRMBatchResults)
+	}
+
+	byID := make(map[string]interface{}, len(items))
+	if b.ID != "" {
+		for _, item := range items {
+			if id, ok := lookup(item, b.ID); ok {
+				byID[fmt.Sprint(id)] = item
+			}
+		}
+	}
+
+	results := make([]Result, len(records))
+	for i, r := range records {
+		var item interface{}
+		switch {
+		case b.ID == "" && i < len(items):
+			item = items[i]
+		case b.ID != "":
+			if item, ok = byID[strconv.Itoa(r.ID)]; !ok {
+				item = byID[r.IdempotencyKey]
+			}
+		}
+		results[i] = b.result(resp, item)
+	}
+	return results, nil
+}
+
+func (b *Batch) result(resp *Response, item interface{}) Result {
+	if item == nil {
+		return Result{Outcome: Retryable, Error: c.ErrCRMBatchItem}
+	}
+	body, _ := json.Marshal(item)
+
+	status := resp.Status
+	if b.Status != "" {
+		v, _ := lookup(item, b.Status)
+		s, err := strconv.Atoi(strings.TrimSpace(fmt.Sprint(v)))
+		if err != nil {
+			return Result{Outcome: Permanent, Error: fmt.Sprintf("%s: %v", c.ErrCRMBatchStatus, v), Body: body}
+		}
+		status = s
+	}
+	return Result{
+		Outcome: Classify(&Response{Status: status}, nil),
+		Status:  status,
+		Error:   fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Body:    body,
+	}
+}
+
+// lookup Value at a dotted path, e.g. data.results or items.0.id.
+// An empty path is the value itself.
+func lookup(v interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return v, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
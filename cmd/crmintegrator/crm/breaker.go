@@ -0,0 +1,189 @@
+package crm
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// BreakerState State of a circuit breaker.
+type BreakerState int
+
+const (
+	// Closed Requests are sent.
+	Closed BreakerState = iota
+	// Open The CRM is down: no request is sent until the cooldown is over.
+	Open
+	// HalfOpen One request is sent as a probe. It closes the breaker if it
+	// succeeds or opens it again otherwise.
+	HalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	}
+	return "half-open"
+}
+
+// ErrCircuitOpen A request hasn't been sent since the breaker is open.
+var ErrCircuitOpen = errors.New(c.ErrCRMCircuitOpen)
+
+// breakerMetrics State, transitions & rejected requests of every breaker,
+// by endpoint. Published by expvar at /debug/vars.
+var breakerMetrics = expvar.NewMap("crm_breaker")
+
+// Breaker Circuit breaker of a CRM endpoint. It opens after Failures
+// outages in a row: requests without response or with a 5xx status.
+type Breaker struct {
+	Next     Sender
+	Endpoint string
+	Failures int
+	Cooldown time.Duration
+	// Now Current time. time.Now if nil.
+	Now func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	metrics  *expvar.Map
+}
+
+// NewBreaker Breaker of endpoint, which opens after failures outages in a
+// row for cooldown.
+func NewBreaker(next Sender, endpoint string, failures int, cooldown time.Duration) *Breaker {
+	b := &Breaker{
+		Next:     next,
+		Endpoint: endpoint,
+		Failures: failures,
+		Cooldown: cooldown,
+		metrics:  new(expvar.Map).Init(),
+	}
+	b.metrics.Set("state", stateVar(Closed))
+	breakerMetrics.Set(endpoint, b.metrics)
+	return b
+}
+
+// Send Send through Next, unless the breaker is open.
+func (b *Breaker) Send(ctx context.Context, r Request) (*Response, error) {
+	if !b.allow() {
+		b.metrics.Add("rejected", 1)
+		return nil, ErrCircuitOpen
+	}
+	resp, err := b.Next.Send(ctx, r)
+	if ctx.Err() == nil {
+		b.record(err != nil || resp.Status >= 500)
+	} else {
+		b.abort()
+	}
+	return resp, err
+}
+
+// State Current state.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Ready How long until a request could be sent. 0 if it could be now.
+func (b *Breaker) Ready() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.state == Open:
+		if d := b.openedAt.Add(b.Cooldown).Sub(b.now()); d > 0 {
+			return d
+		}
+	case b.state == HalfOpen && b.probing:
+		return c.BreakerPoll
+	}
+	return 0
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.Cooldown {
+			return false
+		}
+		b.transition(HalfOpen)
+		b.probing = true
+		return true
+	case HalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *Breaker) record(outage bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Closed:
+		if !outage {
+			b.failures = 0
+			return
+		}
+		if b.failures++; b.failures >= b.Failures {
+			b.open()
+		}
+	case HalfOpen:
+		b.probing = false
+		if outage {
+			b.open()
+			return
+		}
+		b.failures = 0
+		b.transition(Closed)
+	}
+}
+
+// abort A request stopped by its context says nothing about the CRM.
+func (b *Breaker) abort() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+func (b *Breaker) open() {
+	b.openedAt = b.now()
+	b.metrics.Add("opened", 1)
+	b.transition(Open)
+}
+
+func (b *Breaker) transition(to BreakerState) {
+	log.Printf("CRM circuit breaker of %s: %s -> %s\n", b.Endpoint, b.state, to)
+	b.state = to
+	b.metrics.Set("state", stateVar(to))
+	b.metrics.Add("transitions", 1)
+}
+
+func (b *Breaker) now() time.Time {
+	if b.Now != nil {
+		return b.Now()
+	}
+	return time.Now()
+}
+
+func stateVar(s BreakerState) *expvar.String {
+	v := new(expvar.String)
+	v.Set(s.String())
+	return v
+}
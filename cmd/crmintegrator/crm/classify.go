@@ -0,0 +1,71 @@
+package crm
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Outcome What should be done with a row after a request.
+type Outcome int
+
+const (
+	// Success The CRM has the row.
+	Success Outcome = iota
+	// Retryable The request could succeed later, e.g. a timeout or a 503.
+	Retryable
+	// Permanent The request will never succeed, e.g. a 400 or a 422.
+	Permanent
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Success:
+		return "success"
+	case Retryable:
+		return "retryable"
+	}
+	return "permanent"
+}
+
+// Classify A request without response is retryable, as any 408, 425, 429
+// or 5xx. Any other status but 2xx is permanent.
+func Classify(resp *Response, err error) Outcome {
+	if err != nil || resp == nil {
+		return Retryable
+	}
+	switch s := resp.Status; {
+	case s >= 200 && s < 300:
+		return Success
+	case s == http.StatusRequestTimeout, s == http.StatusTooEarly, s == http.StatusTooManyRequests, s >= 500:
+		return Retryable
+	}
+	return Permanent
+}
+
+// RetryAfter How long to wait, according to the Retry-After header of a
+// 429 or 503 response. It's given in seconds or as an Http date.
+func RetryAfter(resp *Response, now time.Time) (time.Duration, bool) {
+	if resp == nil || (resp.Status != http.StatusTooManyRequests && resp.Status != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	v := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
@@ -0,0 +1,225 @@
+package crm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"github.com/josesolana/csv-reader/config"
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// Request A row to be sent to the CRM.
+type Request struct {
+	// ID Row's id, which could be used by the path template, e.g. /{{.ID}}
+	ID   int
+	Body []byte
+	// IdempotencyKey Same key on every attempt of a row, so the CRM doesn't
+	// create it twice. Sent into the idempotency header, if any.
+	IdempotencyKey string
+}
+
+// Response What the CRM answered.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Sender Sends rows to a CRM.
+type Sender interface {
+	Send(ctx context.Context, r Request) (*Response, error)
+}
+
+// Client JSON API CRM client.
+type Client struct {
+	http    *http.Client
+	baseURL string
+	path    *template.Template
+	method  string
+	header  http.Header
+	// idempotency Header which carries Request.IdempotencyKey.
+	idempotency string
+	// auth Credentials. nil if the CRM needs none.
+	auth Auth
+	// limit Waits for the rate limiter before a request is sent again
+	// after a 401. nil if the rate isn't limited.
+	limit func(ctx context.Context) error
+}
+
+// NewSender Client set up from the crm settings, which is rate limited
+// if crm_rate or crm_max_in_flight is set & fails on purpose if
+// chaos_fail_rate is set.
+func NewSender(cfg *config.Config) (Sender, error) {
+	cl, err := NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var s Sender = cl
+	if cfg.CRMRate > 0 || cfg.CRMMaxInFlight > 0 {
+		l := NewLimiter(cl, cfg.CRMRate, cfg.CRMBurst, cfg.CRMMaxInFlight)
+		cl.limit = l.wait
+		s = l
+	}
+	if cfg.ChaosFailRate == 0 {
+		return s, nil
+	}
+	log.Printf("Chaos mode: %d%% of CRM requests fail on purpose\n", cfg.ChaosFailRate)
+	return &Chaos{
+		Next:     s,
+		FailRate: cfg.ChaosFailRate,
+		Rand:     func() int { return rand.Intn(100) },
+	}, nil
+}
+
+// NewClient Client set up from the crm settings.
+func NewClient(cfg *config.Config) (*Client, error) {
+	path, err := template.New("path").Parse(cfg.CRMPath)
+	if err != nil {
+		log.Printf("Cannot parse CRM path template: %s\n", cfg.CRMPath)
+		return nil, err
+	}
+	header, err := ParseHeaders(cfg.CRMHeaders)
+	if err != nil {
+		return nil, err
+	}
+	header.Set("Content-Type", "application/json")
+	auth, err := NewAuth(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		http:        &http.Client{Timeout: cfg.TimeOut},
+		baseURL:     strings.TrimRight(cfg.CRMUrl, "/"),
+		path:        path,
+		method:      strings.ToUpper(cfg.CRMMethod),
+		header:      header,
+		idempotency: cfg.IdempotencyHeader,
+		auth:        auth,
+	}, nil
+}
+
+// Send Make a request. An error means there is no response,
+// e.g. a timeout. Any status is returned as a Response.
+// A 401 makes the client authenticate & send the request once again,
+// within the rate limit.
+func (cl *Client) Send(ctx context.Context, r Request) (*Response, error) {
+	u, err := cl.url(r)
+	if err != nil {
+		log.Printf("Cannot build the CRM url. ID: %d. Error: %s\n", r.ID, err)
+		return nil, err
+	}
+
+	resp, req, err := cl.do(ctx, u, r)
+	if err == nil && resp.Status == http.StatusUnauthorized && cl.auth != nil {
+		log.Printf("JSON API answered 401. Authenticating again. ID: %d\n", r.ID)
+		cl.auth.Invalidate(req)
+		if cl.limit != nil {
+			if err := cl.limit(ctx); err != nil {
+				return nil, err
+			}
+		}
+		resp, _, err = cl.do(ctx, u, r)
+	}
+	return resp, err
+}
+
+// do Make a request. The request sent is returned as well.
+func (cl *Client) do(ctx context.Context, u string, r Request) (*Response, *http.Request, error) {
+	req, err := http.NewRequest(cl.method, u, bytes.NewReader(r.Body))
+	if err != nil {
+		log.Printf("Cannot make a %s request. ID: %d. Error: %s\n", cl.method, r.ID, err)
+		return nil, nil, err
+	}
+	for k, v := range cl.header {
+		req.Header[k] = v
+	}
+	if cl.idempotency != "" && r.IdempotencyKey != "" {
+		req.Header.Set(cl.idempotency, r.IdempotencyKey)
+	}
+	if cl.auth != nil {
+		if err := cl.auth.Apply(ctx, req); err != nil {
+			return nil, nil, err
+		}
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := cl.http.Do(req)
+	if err != nil {
+		return nil, req, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, req, err
+	}
+	return &Response{Status: resp.StatusCode, Header: resp.Header, Body: body}, req, nil
+}
+
+func (cl *Client) url(r Request) (string, error) {
+	var path bytes.Buffer
+	if err := cl.path.Execute(&path, r); err != nil {
+		return "", err
+	}
+	p := path.String()
+	if p != "" && !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	u := cl.baseURL + p
+	_, err := url.Parse(u)
+	return u, err
+}
+
+// ParseHeaders Parse headers written as "Name: value; Other: value".
+func ParseHeaders(s string) (http.Header, error) {
+	header := make(http.Header)
+	for _, h := range strings.Split(s, ";") {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		i := strings.Index(h, ":")
+		if i <= 0 {
+			log.Printf("CRM header invalid: %s\n", h)
+			return nil, errors.New(c.ErrCRMHeader)
+		}
+		header.Add(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+	return header, nil
+}
+
+// errChaos Used by Chaos to fake a request without response.
+var errChaos = errors.New(c.ErrCRMChaos)
+
+// Chaos Fault injection for tests. FailRate percent of requests fail
+// without being sent: half of them by a 503 response and half of them
+// as if there were no response.
+type Chaos struct {
+	Next     Sender
+	FailRate int
+	// Rand Random number from 0 to 99.
+	Rand func() int
+}
+
+// Send Fail or send through Next.
+func (ch *Chaos) Send(ctx context.Context, r Request) (*Response, error) {
+	if n := ch.Rand(); n < ch.FailRate {
+		if n%2 == 0 {
+			return nil, errChaos
+		}
+		return &Response{
+			Status: http.StatusServiceUnavailable,
+			Header: make(http.Header),
+			Body:   []byte(http.StatusText(http.StatusServiceUnavailable)),
+		}, nil
+	}
+	return ch.Next.Send(ctx, r)
+}
@@ -0,0 +1,25 @@
+package crm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// IdempotencyKey Key which lets the CRM tell a retry from a new row.
+// By id it's "table:id:version", version being the start of the body's
+// SHA-256. By hash it's the SHA-256 of the table name & the body.
+// Either way a row overwritten with new content gets a new key.
+func IdempotencyKey(mode, table string, id int, body []byte) string {
+	if mode == c.IdempotencyByHash {
+		h := sha256.New()
+		h.Write([]byte(table))
+		h.Write([]byte{0})
+		h.Write(body)
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	sum := sha256.Sum256(body)
+	return table + ":" + strconv.Itoa(id) + ":" + hex.EncodeToString(sum[:c.IdempotencyVersionSize])
+}
@@ -0,0 +1,157 @@
+package crm
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// Limiter Token bucket shared by every worker, which caps the requests
+// per second & in flight. The rate adapts to the CRM (AIMD): it's halved
+// by a 429 or a Retry-After & it grows back by every success, up to Max.
+// Requests sent before the last decrease don't decrease it again, so
+// workers throttled at once halve it only once.
+type Limiter struct {
+	Next Sender
+	// Max Requests per second it starts with & never exceeds. 0 for no
+	// rate limit.
+	Max float64
+	// Min Floor of the rate.
+	Min float64
+	// Burst Requests made at once after an idle time.
+	Burst int
+	// Increase Requests per second added by every success.
+	Increase float64
+	// Decrease The rate is multiplied by it after a 429 or a Retry-After.
+	Decrease float64
+	// Now Current time. time.Now if nil.
+	Now func() time.Time
+
+	inFlight chan struct{}
+	mu       sync.Mutex
+	rate     float64
+	tokens   float64
+	last     time.Time
+	// decreased When the rate has been decreased last.
+	decreased time.Time
+}
+
+// NewLimiter Limiter of rate requests per second & maxInFlight requests
+// at once. 0 means no limit.
+func NewLimiter(next Sender, rate float64, burst, maxInFlight int) *Limiter {
+	l := &Limiter{
+		Next:     next,
+		Max:      rate,
+		Min:      rate * c.RateMin,
+		Burst:    burst,
+		Increase: rate * c.RateIncrease,
+		Decrease: c.RateDecrease,
+		rate:     rate,
+		tokens:   float64(burst),
+	}
+	if maxInFlight > 0 {
+		l.inFlight = make(chan struct{}, maxInFlight)
+	}
+	return l
+}
+
+// Send Wait for a free slot & a token, send through Next & adapt the rate
+// to the response.
+func (l *Limiter) Send(ctx context.Context, r Request) (*Response, error) {
+	if l.inFlight != nil {
+		select {
+		case l.inFlight <- struct{}{}:
+			defer func() { <-l.inFlight }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if err := l.wait(ctx); err != nil {
+		return nil, err
+	}
+	sent := l.now()
+	resp, err := l.Next.Send(ctx, r)
+	l.adapt(resp, sent)
+	return resp, err
+}
+
+// Rate Current requests per second.
+func (l *Limiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// wait Take a token, waiting for it if the bucket is empty.
+func (l *Limiter) wait(ctx context.Context) error {
+	if l.Max <= 0 {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		now := l.now()
+		if !l.last.IsZero() {
+			l.tokens += now.Sub(l.last).Seconds() * l.rate
+		}
+		if burst := float64(l.Burst); l.tokens > burst {
+			l.tokens = burst
+		}
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		d := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
+// adapt Slow down on a 429 or a Retry-After to a request sent after the
+// last decrease. Speed up on a success.
+func (l *Limiter) adapt(resp *Response, sent time.Time) {
+	if l.Max <= 0 || resp == nil {
+		return
+	}
+	_, retryAfter := RetryAfter(resp, l.now())
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case resp.Status == http.StatusTooManyRequests || retryAfter:
+		if sent.Before(l.decreased) {
+			return
+		}
+		l.decreased = l.now()
+		l.rate *= l.Decrease
+		if l.rate < l.Min {
+			l.rate = l.Min
+		}
+		// A burst is what the CRM doesn't want now.
+		if l.tokens > 0 {
+			l.tokens = 0
+		}
+		log.Printf("JSON API is overloaded. Rate lowered to %.2f requests per second\n", l.rate)
+	case resp.Status >= 200 && resp.Status < 300 && l.rate < l.Max:
+		l.rate += l.Increase
+		if l.rate > l.Max {
+			l.rate = l.Max
+		}
+	}
+}
+
+func (l *Limiter) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
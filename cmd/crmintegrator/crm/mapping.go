@@ -0,0 +1,217 @@
+package crm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// Mapping Renders a row into the JSON object sent to the CRM.
+//
+// It's a JSON object whose keys are the fields of the payload, nested by
+// dots, e.g. address.city. A value is either:
+//
+// - A constant: a number, a boolean or null.
+//
+// - A text/template rendered with the row, keyed by the columns original
+// names, e.g. "{{.email | lower}}" or "{{index . \"First Name\"}}".
+//
+// - An object with a template & the type of its result:
+// {"template": "{{.age}}", "type": "int"}. Types are string, int, float,
+// bool & json. An empty result is null, but for strings.
+type Mapping struct {
+	fields []mappedField
+}
+
+type mappedField struct {
+	path     []string
+	tmpl     *template.Template
+	kind     string
+	constant interface{}
+}
+
+// mappingFuncs Transforms available into templates.
+var mappingFuncs = template.FuncMap{
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"trim":    strings.TrimSpace,
+	"replace": func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"concat":  func(s ...string) string { return strings.Join(s, "") },
+	"default": func(d, s string) string {
+		if s == "" {
+			return d
+		}
+		return s
+	},
+	// date Parse a value with the layout in & format it with out, e.g.
+	// {{date "02/01/2006" "2006-01-02" .signup}}. Empty values stay empty.
+	"date": func(in, out, s string) (string, error) {
+		if strings.TrimSpace(s) == "" {
+			return "", nil
+		}
+		t, err := time.Parse(in, strings.TrimSpace(s))
+		if err != nil {
+			return "", err
+		}
+		return t.Format(out), nil
+	},
+}
+
+// LoadMapping Read a mapping file. Templates can only use columns.
+func LoadMapping(path string, columns []string) (*Mapping, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("Cannot read the mapping file: %s\n", path)
+		return nil, err
+	}
+	return ParseMapping(data, columns)
+}
+
+// ParseMapping Parse a mapping & check it by rendering an empty row.
+func ParseMapping(data []byte, columns []string) (*Mapping, error) {
+	var spec map[string]json.RawMessage
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, errors.New(c.ErrMappingColumns)
+	}
+
+	keys := make([]string, 0, len(spec))
+	for k := range spec {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	m := &Mapping{}
+	for _, k := range keys {
+		f, err := parseField(k, spec[k])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", c.ErrMapping, k, err)
+		}
+		m.fields = append(m.fields, f)
+	}
+
+	empty := make(map[string]string, len(columns))
+	for _, col := range columns {
+		empty[col] = ""
+	}
+	if _, err := m.Render(empty); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func parseField(key string, raw json.RawMessage) (mappedField, error) {
+	f := mappedField{path: strings.Split(key, "."), kind: c.MappingString}
+	for _, p := range f.path {
+		if p == "" {
+			return f, errors.New("empty field name")
+		}
+	}
+
+	var text string
+	var typed struct {
+		Template string `json:"template"`
+		Type     string `json:"type"`
+	}
+	switch raw = bytes.TrimSpace(raw); {
+	case len(raw) > 0 && raw[0] == '"':
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return f, err
+		}
+	case len(raw) > 0 && raw[0] == '{':
+		if err := json.Unmarshal(raw, &typed); err != nil {
+			return f, err
+		}
+		text = typed.Template
+		if typed.Type != "" {
+			f.kind = typed.Type
+		}
+	default:
+		return f, json.Unmarshal(raw, &f.constant)
+	}
+
+	switch f.kind {
+	case c.MappingString, c.MappingInt, c.MappingFloat, c.MappingBool, c.MappingJSON:
+	default:
+		return f, fmt.Errorf("unknown type %q", f.kind)
+	}
+	tmpl, err := template.New(key).Funcs(mappingFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return f, err
+	}
+	f.tmpl = tmpl
+	return f, nil
+}
+
+// Render The payload of a row, keyed by the columns original names.
+// NULL values are empty.
+func (m *Mapping) Render(row map[string]string) (map[string]interface{}, error) {
+	obj := make(map[string]interface{})
+	for _, f := range m.fields {
+		v := f.constant
+		if f.tmpl != nil {
+			var out bytes.Buffer
+			if err := f.tmpl.Execute(&out, row); err != nil {
+				return nil, fmt.Errorf("%s: %s", c.ErrMapping, err)
+			}
+			var err error
+			if v, err = coerce(f.kind, out.String()); err != nil {
+				return nil, fmt.Errorf("%s: %s: %s", c.ErrMapping, strings.Join(f.path, "."), err)
+			}
+		}
+		if err := set(obj, f.path, v); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+func coerce(kind, s string) (interface{}, error) {
+	if kind == c.MappingString {
+		return s, nil
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	switch kind {
+	case c.MappingInt:
+		return strconv.ParseInt(s, 10, 64)
+	case c.MappingFloat:
+		return strconv.ParseFloat(s, 64)
+	case c.MappingBool:
+		return strconv.ParseBool(s)
+	}
+	if !json.Valid([]byte(s)) {
+		return nil, errors.New("invalid JSON")
+	}
+	return json.RawMessage(s), nil
+}
+
+// set Put v at path, creating the nested objects.
+func set(obj map[string]interface{}, path []string, v interface{}) error {
+	for _, p := range path[:len(path)-1] {
+		next, ok := obj[p]
+		if !ok {
+			next = make(map[string]interface{})
+			obj[p] = next
+		}
+		if obj, ok = next.(map[string]interface{}); !ok {
+			return fmt.Errorf("%s: %s is not an object", c.ErrMapping, strings.Join(path, "."))
+		}
+	}
+	obj[path[len(path)-1]] = v
+	return nil
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+
+	"github.com/lib/pq"
+
+	"github.com/josesolana/csv-reader/config"
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// Conn A connection pool & a listener, shared by the tables an
+// integrator sends. Every table listens to its own channel.
+type Conn struct {
+	db       *sql.DB
+	listener *pq.Listener
+	mu       sync.Mutex
+	// notified Channel of every table listened to.
+	notified map[string]chan struct{}
+}
+
+// NewConn Connect to the database & start listening.
+func NewConn(cfg config.Database) *Conn {
+	cn := &Conn{
+		db:       ConnectDb(cfg),
+		notified: make(map[string]chan struct{}),
+	}
+	cn.listener = pq.NewListener(cfg.DSN(), c.ListenMinReconnect, c.ListenMaxReconnect,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("Listener got an error: %s\n", err)
+			}
+		})
+
+	go func() {
+		// Notify is closed by listener.Close.
+		for n := range cn.listener.Notify {
+			cn.mu.Lock()
+			for table, ch := range cn.notified {
+				// nil after a reconnection: notifications may be lost.
+				if n != nil && n.Channel != table {
+					continue
+				}
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+			cn.mu.Unlock()
+		}
+	}()
+	return cn
+}
+
+// listen LISTEN to the table's channel. Without it, the table is only
+// polled.
+func (cn *Conn) listen(name string) chan struct{} {
+	ch := make(chan struct{}, 1)
+	cn.mu.Lock()
+	cn.notified[name] = ch
+	cn.mu.Unlock()
+	if err := cn.listener.Listen(name); err != nil {
+		log.Printf("Cannot listen to %s, rows are polled. Error: %s\n", name, err)
+	}
+	return ch
+}
+
+// unlisten Stop listening to the table's channel.
+func (cn *Conn) unlisten(name string) error {
+	cn.mu.Lock()
+	delete(cn.notified, name)
+	cn.mu.Unlock()
+	if err := cn.listener.Unlisten(name); err != nil && err != pq.ErrChannelNotOpen {
+		return err
+	}
+	return nil
+}
+
+// Close Close the listener & the connection pool.
+func (cn *Conn) Close() error {
+	err := cn.listener.Close()
+	if dbErr := cn.db.Close(); err == nil {
+		err = dbErr
+	}
+	return err
+}
@@ -4,39 +4,153 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/josesolana/csv-reader/config"
 	c "github.com/josesolana/csv-reader/constants"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
 // Db Database Handler & Wrapper
 type Db struct {
-	db                               *sql.DB
-	read, isProcessed, increaseRetry *sql.Stmt
-	tx                               *sql.Tx
+	db                                *sql.DB
+	claim, isProcessed, increaseRetry *sql.Stmt
+	deadLetter, idempotency, release  *sql.Stmt
+	progress                          *sql.Stmt
+	name                              string
+	// claimedBy Instance which claims rows, unique per process.
+	claimedBy string
+	// conn Shared with the other tables of the integrator, unless the
+	// Db owns it.
+	conn     *Conn
+	owned    bool
+	notified chan struct{}
+	// columns Data columns & their original names into the file.
+	columns, original []string
 }
 
-// NewDB Set up the environment.
-func NewDB(name string) DB {
+// NewDB Set up the environment, on a connection of its own. An error
+// means the table cannot be sent, e.g. it doesn't exist.
+func NewDB(name string, cfg *config.Config) (DB, error) {
+	conn := NewConn(cfg.Database)
+	db, err := newDB(name, cfg, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	db.owned = true
+	return db, nil
+}
+
+// NewDBWithConn Set up the environment on a shared connection, which is
+// left open by Close.
+func NewDBWithConn(name string, cfg *config.Config, conn *Conn) (DB, error) {
+	return newDB(name, cfg, conn)
+}
+
+func newDB(name string, cfg *config.Config, conn *Conn) (*Db, error) {
 	db := &Db{
-		db: ConnectDb(),
+		db:        conn.db,
+		conn:      conn,
+		name:      name,
+		claimedBy: instanceID(),
 	}
 
-	if err := db.checkTableExist(name); err != nil {
-		log.Fatalln(err)
+	if err := db.setUp(name, cfg); err != nil {
+		db.closeStatements()
+		return nil, err
 	}
-	db.createRead(name)
-	db.createUpdateIsProcessed(name)
-	db.createUpdateIncreaseRetry(name)
-	return db
+	db.notified = conn.listen(name)
+	return db, nil
+}
+
+// setUp Add the columns the integrator needs & prepare the statements.
+func (d *Db) setUp(name string, cfg *config.Config) error {
+	if err := d.checkTableExist(name); err != nil {
+		return err
+	}
+	if err := d.addStateColumns(name); err != nil {
+		return err
+	}
+	if err := createDeadLetterTable(d.db); err != nil {
+		return err
+	}
+	if err := d.moveExhausted(name, cfg.TotalRetry); err != nil {
+		return err
+	}
+	d.loadColumns(name)
+	if len(d.columns) == 0 {
+		d.loadDataColumns(name)
+	}
+	if err := d.createClaim(name, cfg); err != nil {
+		return err
+	}
+	if err := d.createUpdateIsProcessed(name); err != nil {
+		return err
+	}
+	if err := d.createUpdateIncreaseRetry(name, cfg.TotalRetry); err != nil {
+		return err
+	}
+	if err := d.createUpdateDeadLetter(name); err != nil {
+		return err
+	}
+	if err := d.createUpdateIdempotency(name); err != nil {
+		return err
+	}
+	if err := d.createRelease(name); err != nil {
+		return err
+	}
+	return d.createProgress(name)
+}
+
+// Progress Rows of a table by their state.
+type Progress struct {
+	Processed   int
+	DeadLetters int
+	// Pending Rows which will be sent, now or after a failure.
+	Pending int
+}
+
+// Failure Why a row couldn't be sent.
+type Failure struct {
+	// Status Http status. 0 if there is no response.
+	Status int
+	// Error What went wrong.
+	Error string
+	// Response Response body, cut to c.ResponseSnippet bytes.
+	Response string
+}
+
+func (f Failure) status() interface{} {
+	if f.Status == 0 {
+		return nil
+	}
+	return f.Status
+}
+
+// response Valid UTF-8 without NUL, which Postgres doesn't accept as text.
+func (f Failure) response() string {
+	r := f.Response
+	if len(r) > c.ResponseSnippet {
+		r = r[:c.ResponseSnippet]
+	}
+	return strings.Map(func(c rune) rune {
+		if c == 0 {
+			return -1
+		}
+		return c
+	}, strings.ToValidUTF8(r, string(utf8.RuneError)))
 }
 
 // ConnectDb Set Driver, user, pass & database name
-func ConnectDb() *sql.DB {
+func ConnectDb(cfg config.Database) *sql.DB {
 
-	db, err := sql.Open(c.DbDriver, fmt.Sprintf("user=%s dbname=%s password =%s sslmode=disable", c.DbUser, getDBName(), c.DbPass))
+	db, err := sql.Open(c.DbDriver, cfg.DSN())
 	if err != nil {
 		log.Fatalf("Couldn't connect to Database. Error: %s\n", err)
 	}
@@ -48,69 +162,124 @@ func ConnectDb() *sql.DB {
 	return db
 }
 
-// Begin Begin a transaction
-func (d *Db) Begin() error {
-	tx, err := d.db.Begin()
+// instanceID Host name, pid & a random suffix.
+func instanceID() string {
+	host, err := os.Hostname()
 	if err != nil {
-		return err
+		host = "unknown"
 	}
-	d.tx = tx
-	return nil
-}
-
-// Commit Commit a transaction
-func (d *Db) Commit() error {
-	return d.tx.Commit()
+	return fmt.Sprintf("%s:%d:%08x", host, os.Getpid(), rand.Uint32())
 }
 
-// Reads from DB
-func (d *Db) Read() (*sql.Rows, error) {
-	rows, err := d.read.Query()
+// Claim Lease a batch of pending rows to this instance & return them.
+// The lease is committed at once, so rows are finalized one by one and
+// another instance only takes them once the lease has expired.
+func (d *Db) Claim() (*sql.Rows, error) {
+	rows, err := d.claim.Query(d.claimedBy)
 	if err != nil {
 		return nil, err
 	}
 	return rows, nil
 }
 
+// Notified Receives when rows may be waiting: csvreader has inserted
+// some, or the listener has reconnected & notifications may be lost.
+// Notifications are merged while nobody receives them.
+func (d *Db) Notified() <-chan struct{} {
+	return d.notified
+}
+
+// Release Give back rows leased by this instance which haven't been
+// finalized, so they don't wait for their lease to expire. Their retries
+// are untouched. Every leased row if no id is given.
+func (d *Db) Release(ids ...int) error {
+	ids64 := make([]int64, len(ids))
+	for i, id := range ids {
+		ids64[i] = int64(id)
+	}
+	res, err := d.release.Exec(d.claimedBy, pq.Array(ids64))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 && len(ids) == 0 {
+		log.Printf("%d claimed rows have been released\n", n)
+	}
+	return nil
+}
+
+// Columns Original names of the columns read after id, is_processed,
+// retry & idempotency_key. nil if they are unknown.
+func (d *Db) Columns() []string {
+	return d.original
+}
+
 // SetAsProcessed Set a row as processed to not be taking into accout
-// in the next lap.
+// in the next lap. Like any other update of a row, it's skipped if the
+// lease has been lost, since the row is another instance's.
 func (d *Db) SetAsProcessed(id int) error {
-	if _, err := d.isProcessed.Exec(id); err != nil {
+	if _, err := d.isProcessed.Exec(id, d.claimedBy); err != nil {
+		return err
+	}
+	return nil
+}
+
+// IncreaseRetry Increase by one retry value and save why it failed.
+// The row is not read again until after. A row which exhausts its
+// retries becomes a dead letter.
+func (d *Db) IncreaseRetry(id int, after time.Duration, f Failure) error {
+	ms := float64(after) / float64(time.Millisecond)
+	if _, err := d.increaseRetry.Exec(id, f.status(), f.Error, f.response(), d.name, ms, d.claimedBy); err != nil {
 		return err
 	}
 	return nil
 }
 
-// IncreaseRetry Increase by one retry value.
-func (d *Db) IncreaseRetry(id int) error {
-	if _, err := d.increaseRetry.Exec(id); err != nil {
+// SetAsDeadLetter Set a row as dead letter, it will not be sent again.
+func (d *Db) SetAsDeadLetter(id int, f Failure) error {
+	if _, err := d.deadLetter.Exec(id, f.status(), f.Error, f.response(), d.name, d.claimedBy); err != nil {
 		return err
 	}
 	return nil
 }
 
+// SetIdempotencyKey Save key as the row's idempotency key, unless it
+// already has one. The saved key is returned.
+func (d *Db) SetIdempotencyKey(id int, key string) (string, error) {
+	var saved string
+	if err := d.idempotency.QueryRow(id, key).Scan(&saved); err != nil {
+		return "", err
+	}
+	return saved, nil
+}
+
+// Progress Count the table's rows by their state.
+func (d *Db) Progress() (Progress, error) {
+	var p Progress
+	err := d.progress.QueryRow().Scan(&p.Processed, &p.DeadLetters, &p.Pending)
+	return p, err
+}
+
 //Close returns the connection to the connection pool.
 func (d *Db) Close() []error {
 	errs := make([]error, 0)
 
-	if err := d.increaseRetry.Close(); err != nil {
-		log.Println("Cannot Close increaseRetry")
+	if err := d.Release(); err != nil {
+		log.Println("Cannot Release claimed rows")
 		errs = append(errs, err)
 	}
 
-	if err := d.isProcessed.Close(); err != nil {
-		log.Println("Cannot Close isProcessed")
+	if err := d.conn.unlisten(d.name); err != nil {
+		log.Println("Cannot Unlisten")
 		errs = append(errs, err)
 	}
 
-	if err := d.read.Close(); err != nil {
-		log.Println("Cannot Close Read")
-		errs = append(errs, err)
-	}
+	errs = append(errs, d.closeStatements()...)
 
-	if err := d.db.Close(); err != nil {
-		log.Println("Cannot Close DB Connection")
-		errs = append(errs, err)
+	if d.owned {
+		if err := d.conn.Close(); err != nil {
+			log.Println("Cannot Close DB Connection")
+			errs = append(errs, err)
+		}
 	}
 	if len(errs) > 0 {
 		return errs
@@ -118,76 +287,308 @@ func (d *Db) Close() []error {
 	return errs
 }
 
-func (d *Db) createRead(name string) {
+// closeStatements Close the statements which have been prepared.
+func (d *Db) closeStatements() []error {
+	stmts := []struct {
+		name string
+		stmt *sql.Stmt
+	}{
+		{"progress", d.progress},
+		{"release", d.release},
+		{"idempotency", d.idempotency},
+		{"deadLetter", d.deadLetter},
+		{"increaseRetry", d.increaseRetry},
+		{"isProcessed", d.isProcessed},
+		{"claim", d.claim},
+	}
+	var errs []error
+	for _, s := range stmts {
+		if s.stmt == nil {
+			continue
+		}
+		if err := s.stmt.Close(); err != nil {
+			log.Printf("Cannot Close %s\n", s.name)
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// stateColumns Columns of a table which aren't read from its file.
+var stateColumns = []string{
+	"id", "is_processed", "retry", "dead_letter", "last_status", "last_error",
+	"last_response", "next_attempt_at", "idempotency_key", "claimed_by", "claimed_until",
+}
+
+// loadColumns Columns saved by csvreader. Tables imported before they
+// were saved have no original names.
+func (d *Db) loadColumns(name string) {
+
 	query := `
-	Select id, is_processed, retry, %s.*
+	SELECT column_name, original_name
 	FROM %s
-	WHERE NOT is_processed and retry <= %d
-	LIMIT %d
-	FOR UPDATE SKIP LOCKED`
-	query = fmt.Sprintf(query, name, name, c.TotalRetry, c.BatchSizeRow)
+	WHERE table_name = $1
+	ORDER BY position`
+	rows, err := d.db.Query(fmt.Sprintf(query, c.ColumnsTable), name)
+	if err != nil {
+		log.Printf("Cannot read the columns original names. Error: %s\n", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var column, original string
+		if err := rows.Scan(&column, &original); err != nil {
+			log.Printf("Cannot read the columns original names. Error: %s\n", err)
+			d.columns, d.original = nil, nil
+			return
+		}
+		d.columns = append(d.columns, column)
+		d.original = append(d.original, original)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Cannot read the columns original names. Error: %s\n", err)
+		d.columns, d.original = nil, nil
+	}
+}
+
+// loadDataColumns Columns of the table which aren't state columns,
+// under their own name.
+func (d *Db) loadDataColumns(name string) {
+	query := `
+	SELECT column_name
+	FROM information_schema.columns
+	WHERE table_schema = current_schema() AND table_name = $1
+		AND column_name <> ALL($2)
+	ORDER BY ordinal_position`
+	rows, err := d.db.Query(query, name, pq.Array(stateColumns))
+	if err != nil {
+		log.Printf("Cannot read the columns of %s. Error: %s\n", name, err)
+		return
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			log.Printf("Cannot read the columns of %s. Error: %s\n", name, err)
+			return
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Cannot read the columns of %s. Error: %s\n", name, err)
+		return
+	}
+	d.columns, d.original = columns, columns
+}
+
+// createClaim Only data columns are read after the state needed to send
+// a row, so the payload never carries the state.
+func (d *Db) createClaim(name string, cfg *config.Config) error {
+	table := pq.QuoteIdentifier(name)
+	cols := []string{"id", "is_processed", "retry", "idempotency_key"}
+	for _, col := range d.columns {
+		cols = append(cols, pq.QuoteIdentifier(col))
+	}
+
+	// RETURNING has no order: the claimed rows are sorted afterwards.
+	query := `
+	WITH claimed AS (
+	UPDATE %s
+	SET claimed_by = $1, claimed_until = NOW() + %d * INTERVAL '1 millisecond'
+	WHERE id IN (
+		SELECT id
+		FROM %s
+		WHERE NOT is_processed and NOT dead_letter and retry <= %d
+			and next_attempt_at <= NOW()
+			and (claimed_until IS NULL or claimed_until < NOW())
+		ORDER BY next_attempt_at
+		LIMIT %d
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING %s
+	)
+	SELECT *
+	FROM claimed
+	ORDER BY id`
+	ms := int64(cfg.LeaseTime / time.Millisecond)
+	query = fmt.Sprintf(query, table, ms, table, cfg.TotalRetry, cfg.BatchSizeRow, strings.Join(cols, ", "))
+
+	claim, err := d.db.Prepare(query)
+	if err != nil {
+		log.Printf("Couldn't create claim. Error: %s\n", err)
+		return err
+	}
+	d.claim = claim
+	return nil
+}
+
+func (d *Db) createRelease(name string) error {
+	query := `
+	UPDATE %s
+	SET claimed_by = NULL, claimed_until = NULL
+	WHERE claimed_by = $1 AND NOT is_processed
+		AND (cardinality($2::int[]) = 0 OR id = ANY($2))`
+	query = fmt.Sprintf(query, pq.QuoteIdentifier(name))
+
+	release, err := d.db.Prepare(query)
+	if err != nil {
+		log.Printf("Couldn't create release. Error: %s\n", err)
+		return err
+	}
+	d.release = release
+	return nil
+}
+
+func (d *Db) createProgress(name string) error {
+	query := `
+	SELECT count(*) FILTER (WHERE is_processed),
+		count(*) FILTER (WHERE NOT is_processed AND dead_letter),
+		count(*) FILTER (WHERE NOT is_processed AND NOT dead_letter)
+	FROM %s`
+	query = fmt.Sprintf(query, pq.QuoteIdentifier(name))
 
-	read, err := d.db.Prepare(query)
+	progress, err := d.db.Prepare(query)
 	if err != nil {
-		log.Fatalf("Couldn't create Read. Error: %s\n", err)
+		log.Printf("Couldn't create progress. Error: %s\n", err)
+		return err
 	}
-	d.read = read
+	d.progress = progress
+	return nil
 }
 
-func (d *Db) createUpdateIsProcessed(name string) {
+func (d *Db) createUpdateIsProcessed(name string) error {
 	query := `
 	UPDATE %s
-	SET is_processed = true
-	WHERE id = $1`
-	query = fmt.Sprintf(query, name)
+	SET is_processed = true, claimed_by = NULL, claimed_until = NULL
+	WHERE id = $1 AND claimed_by = $2`
+	query = fmt.Sprintf(query, pq.QuoteIdentifier(name))
 
 	isProcessed, err := d.db.Prepare(query)
 	if err != nil {
-		log.Fatalf("Couldn't create isProcessed. Error: %s\n", err)
+		log.Printf("Couldn't create isProcessed. Error: %s\n", err)
+		return err
 	}
 	d.isProcessed = isProcessed
+	return nil
 }
 
-func (d *Db) createUpdateIncreaseRetry(name string) {
+func (d *Db) createUpdateIncreaseRetry(name string, totalRetry int) error {
 	query := `
 	UPDATE %s
-	SET retry = retry + 1
-	WHERE id = $1`
-	query = fmt.Sprintf(query, name)
+	SET retry = retry + 1, dead_letter = retry + 1 > %d,
+		last_status = $2, last_error = $3, last_response = $4,
+		next_attempt_at = NOW() + $6::float8 * INTERVAL '1 millisecond',
+		claimed_by = NULL, claimed_until = NULL
+	WHERE id = $1 AND claimed_by = $7`
+	query = withDeadLetter(fmt.Sprintf(query, pq.QuoteIdentifier(name), totalRetry), "$5")
 
 	increaseRetry, err := d.db.Prepare(query)
 	if err != nil {
-		log.Fatalf("Couldn't create increaseRetry. Error: %s\n", err)
+		log.Printf("Couldn't create increaseRetry. Error: %s\n", err)
+		return err
 	}
 	d.increaseRetry = increaseRetry
+	return nil
 }
 
-func (d *Db) checkTableExist(name string) error {
+func (d *Db) createUpdateDeadLetter(name string) error {
 	query := `
-	SELECT id
-	FROM %s
-	LIMIT 1`
-	row, err := d.db.Exec(fmt.Sprintf(query, name))
+	UPDATE %s
+	SET dead_letter = true,
+		last_status = $2, last_error = $3, last_response = $4,
+		claimed_by = NULL, claimed_until = NULL
+	WHERE id = $1 AND claimed_by = $6`
+	query = withDeadLetter(fmt.Sprintf(query, pq.QuoteIdentifier(name)), "$5")
 
+	deadLetter, err := d.db.Prepare(query)
 	if err != nil {
-		log.Printf("Cannot verify if table %s exists. Error: %s\n", name, err)
+		log.Printf("Couldn't create deadLetter. Error: %s\n", err)
 		return err
 	}
-	count, err := row.RowsAffected()
+	d.deadLetter = deadLetter
+	return nil
+}
+
+func (d *Db) createUpdateIdempotency(name string) error {
+	query := `
+	UPDATE %s
+	SET idempotency_key = COALESCE(idempotency_key, $2)
+	WHERE id = $1
+	RETURNING idempotency_key`
+	query = fmt.Sprintf(query, pq.QuoteIdentifier(name))
+
+	idempotency, err := d.db.Prepare(query)
 	if err != nil {
-		log.Printf("Cannot verify if table %s exists. Error: %s\n", name, err)
+		log.Printf("Couldn't create idempotency. Error: %s\n", err)
 		return err
 	}
-	if int64(0) == count {
-		log.Printf("Given table %s doesn't exists.\n", name)
-		return errors.New(c.ErrTableNoExists)
+	d.idempotency = idempotency
+	return nil
+}
+
+// moveExhausted Rows which exhausted their retries before dead letters
+// existed, or with a lower total_retry, become dead letters.
+func (d *Db) moveExhausted(name string, totalRetry int) error {
+	query := `
+	UPDATE %s
+	SET dead_letter = true
+	WHERE NOT is_processed AND NOT dead_letter AND retry > %d`
+	query = withDeadLetter(fmt.Sprintf(query, pq.QuoteIdentifier(name), totalRetry), "$1")
+
+	res, err := d.db.Exec(query, name)
+	if err != nil {
+		log.Printf("Couldn't move exhausted rows to dead letters. Error: %s\n", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		log.Printf("%d rows exhausted their retries and have been moved to dead letters\n", n)
+	}
+	return nil
+}
+
+// addStateColumns Columns added after the table was created by csvreader.
+func (d *Db) addStateColumns(name string) error {
+	query := `
+	ALTER TABLE %s
+	ADD COLUMN IF NOT EXISTS dead_letter boolean NOT NULL DEFAULT FALSE,
+	ADD COLUMN IF NOT EXISTS last_status int,
+	ADD COLUMN IF NOT EXISTS last_error text,
+	ADD COLUMN IF NOT EXISTS last_response text,
+	ADD COLUMN IF NOT EXISTS next_attempt_at timestamptz NOT NULL DEFAULT NOW(),
+	ADD COLUMN IF NOT EXISTS idempotency_key text,
+	ADD COLUMN IF NOT EXISTS claimed_by text,
+	ADD COLUMN IF NOT EXISTS claimed_until timestamptz`
+	if _, err := d.db.Exec(fmt.Sprintf(query, pq.QuoteIdentifier(name))); err != nil {
+		log.Printf("Couldn't add state columns. Error: %s\n", err)
+		return err
+	}
+
+	// Pending rows are read by their next attempt.
+	query = `
+	CREATE INDEX IF NOT EXISTS %s
+	ON %s (next_attempt_at)
+	WHERE NOT is_processed AND NOT dead_letter`
+	index := pq.QuoteIdentifier(name + "_next_attempt_at_idx")
+	if _, err := d.db.Exec(fmt.Sprintf(query, index, pq.QuoteIdentifier(name))); err != nil {
+		log.Printf("Couldn't create next_attempt_at index. Error: %s\n", err)
+		return err
 	}
 	return nil
 }
 
-func getDBName() string {
-	if rm := os.Getenv(c.RunMode); strings.ToUpper(rm) == c.Test {
-		return c.DbNameTest
+func (d *Db) checkTableExist(name string) error {
+	var exists bool
+	err := d.db.QueryRow("SELECT to_regclass($1) IS NOT NULL", pq.QuoteIdentifier(name)).Scan(&exists)
+	if err != nil {
+		log.Printf("Cannot verify if table %s exists. Error: %s\n", name, err)
+		return err
 	}
-	return c.DbName
+	if !exists {
+		log.Printf("Given table %s doesn't exists.\n", name)
+		return errors.New(c.ErrTableNoExists)
+	}
+	return nil
 }
@@ -1,13 +1,20 @@
 package database
 
-import "database/sql"
+import (
+	"database/sql"
+	"time"
+)
 
 // DB represents available database operations
 type DB interface {
-	Begin() error
-	Commit() error
-	Read() (*sql.Rows, error)
+	Claim() (*sql.Rows, error)
+	Release(ids ...int) error
+	Notified() <-chan struct{}
+	Columns() []string
 	SetAsProcessed(id int) error
-	IncreaseRetry(id int) error
+	IncreaseRetry(id int, after time.Duration, f Failure) error
+	SetAsDeadLetter(id int, f Failure) error
+	SetIdempotencyKey(id int, key string) (string, error)
+	Progress() (Progress, error)
 	Close() []error
 }
@@ -0,0 +1,202 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/josesolana/csv-reader/config"
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// DeadLetter A row which will not be sent to the CRM again, unless it's
+// requeued.
+type DeadLetter struct {
+	Table string
+	ID    int
+	Retry int
+	// Status Last Http status. 0 if there was no response.
+	Status    int
+	Error     string
+	Response  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// DeadLetters Dead letters handler, used by operators to inspect and
+// replay rows once the CRM is fixed.
+type DeadLetters struct {
+	db *sql.DB
+}
+
+// NewDeadLetters Set up the environment.
+func NewDeadLetters(cfg *config.Config) *DeadLetters {
+	db := ConnectDb(cfg.Database)
+	if err := createDeadLetterTable(db); err != nil {
+		log.Fatalln(err)
+	}
+	return &DeadLetters{db: db}
+}
+
+// List Dead letters of a table, oldest first.
+// Rows overwritten by csvreader since then are not dead letters anymore.
+func (dl *DeadLetters) List(table string) ([]DeadLetter, error) {
+	query := `
+	SELECT d.table_name, d.row_id, d.retry, COALESCE(d.status, 0), d.error, d.response, d.created_at, d.updated_at
+	FROM %s d
+	JOIN %s r ON r.id = d.row_id
+	WHERE d.table_name = $1 AND r.dead_letter
+	ORDER BY d.created_at, d.row_id`
+	rows, err := dl.db.Query(fmt.Sprintf(query, c.DeadLetterTable, pq.QuoteIdentifier(table)), table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var letters []DeadLetter
+	for rows.Next() {
+		var l DeadLetter
+		if err := rows.Scan(&l.Table, &l.ID, &l.Retry, &l.Status, &l.Error, &l.Response, &l.CreatedAt, &l.UpdatedAt); err != nil {
+			return nil, err
+		}
+		letters = append(letters, l)
+	}
+	return letters, rows.Err()
+}
+
+// Show A dead letter with the row it belongs to, by column.
+// sql.ErrNoRows if there is none.
+func (dl *DeadLetters) Show(table string, id int) (*DeadLetter, map[string]string, error) {
+	query := `
+	SELECT table_name, row_id, retry, COALESCE(status, 0), error, response, created_at, updated_at
+	FROM %s
+	WHERE table_name = $1 AND row_id = $2`
+	var l DeadLetter
+	err := dl.db.QueryRow(fmt.Sprintf(query, c.DeadLetterTable), table, id).
+		Scan(&l.Table, &l.ID, &l.Retry, &l.Status, &l.Error, &l.Response, &l.CreatedAt, &l.UpdatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := dl.db.Query(fmt.Sprintf("SELECT * FROM %s WHERE id = $1", pq.QuoteIdentifier(table)), id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	row := make(map[string]string, len(cols))
+	if rows.Next() {
+		vals := make([]sql.NullString, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		for i, col := range cols {
+			row[col] = vals[i].String
+		}
+	}
+	return &l, row, rows.Err()
+}
+
+// Requeue Send rows again: their retries, last failure & idempotency key
+// are reset and they are no longer dead letters, so the CRM doesn't
+// replay the rejected request. Every dead letter of the table if ids is
+// empty.
+// It returns how many rows have been requeued.
+func (dl *DeadLetters) Requeue(table string, ids []int) (int64, error) {
+	query := `
+	WITH requeued AS (
+		DELETE FROM %s
+		WHERE table_name = $1 AND (cardinality($2::int[]) = 0 OR row_id = ANY($2))
+		RETURNING row_id
+	)
+	UPDATE %s
+	SET dead_letter = false, retry = 0, next_attempt_at = NOW(),
+		last_status = NULL, last_error = NULL, last_response = NULL,
+		idempotency_key = NULL, claimed_by = NULL, claimed_until = NULL
+	WHERE id IN (SELECT row_id FROM requeued)`
+	query = fmt.Sprintf(query, c.DeadLetterTable, pq.QuoteIdentifier(table))
+	return dl.exec(query, table, ids)
+}
+
+// Purge Delete dead letters and their rows, which will never be sent.
+// Every dead letter of the table if ids is empty.
+// It returns how many rows have been deleted.
+func (dl *DeadLetters) Purge(table string, ids []int) (int64, error) {
+	query := `
+	WITH purged AS (
+		DELETE FROM %s
+		WHERE table_name = $1 AND (cardinality($2::int[]) = 0 OR row_id = ANY($2))
+		RETURNING row_id
+	)
+	DELETE FROM %s
+	WHERE id IN (SELECT row_id FROM purged)`
+	query = fmt.Sprintf(query, c.DeadLetterTable, pq.QuoteIdentifier(table))
+	return dl.exec(query, table, ids)
+}
+
+func (dl *DeadLetters) exec(query, table string, ids []int) (int64, error) {
+	ids64 := make([]int64, len(ids))
+	for i, id := range ids {
+		ids64[i] = int64(id)
+	}
+	res, err := dl.db.Exec(query, table, pq.Array(ids64))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Close returns the connection to the connection pool.
+func (dl *DeadLetters) Close() error {
+	return dl.db.Close()
+}
+
+// withDeadLetter Save into the dead letters table the rows which an
+// UPDATE sets as dead letters. table is the parameter with the table name.
+func withDeadLetter(update, table string) string {
+	query := `
+	WITH updated AS (%s
+	RETURNING id, retry, dead_letter, last_status, last_error, last_response
+	)
+	INSERT INTO %s (table_name, row_id, retry, status, error, response, created_at, updated_at)
+	SELECT %s, id, retry, last_status, COALESCE(last_error, ''), COALESCE(last_response, ''), NOW(), NOW()
+	FROM updated
+	WHERE dead_letter
+	ON CONFLICT (table_name, row_id) DO UPDATE
+	SET retry = EXCLUDED.retry,
+		status = EXCLUDED.status,
+		error = EXCLUDED.error,
+		response = EXCLUDED.response,
+		updated_at = EXCLUDED.updated_at`
+	return fmt.Sprintf(query, update, c.DeadLetterTable, table)
+}
+
+func createDeadLetterTable(db *sql.DB) error {
+	query := `CREATE TABLE IF NOT EXISTS %s (
+			table_name VARCHAR(255) NOT NULL,
+			row_id INT NOT NULL,
+			retry INT NOT NULL,
+			status INT,
+			error TEXT NOT NULL,
+			response TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			PRIMARY KEY(table_name, row_id)
+			)`
+
+	if _, err := db.Exec(fmt.Sprintf(query, c.DeadLetterTable)); err != nil {
+		log.Printf("Cannot create the %s Table. Error: %s\n", c.DeadLetterTable, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// undefinedTable Postgres error code of a missing table.
+const undefinedTable = "42P01"
+
+// Import A file imported by csvreader, as saved into the registry.
+type Import struct {
+	Table  string
+	Source string
+	Rows   int
+	Status string
+	// Weight Batches claimed from the table every round.
+	Weight    int
+	CreatedAt time.Time
+}
+
+// Registry Imports saved by csvreader, watched by the integrator daemon.
+type Registry struct {
+	db *sql.DB
+}
+
+// NewRegistry Set up the environment, on the connection of the tables.
+func NewRegistry(conn *Conn) *Registry {
+	return &Registry{db: conn.db}
+}
+
+// Active Imports whose rows aren't delivered yet, oldest first.
+// Failed imports, until they are resumed, & imports whose table has been
+// dropped are skipped. There is none until csvreader has created the
+// registry.
+func (r *Registry) Active() ([]Import, error) {
+	query := `
+	SELECT table_name, source, row_count, status, weight, created_at
+	FROM %s
+	WHERE status NOT IN ($1, $2) AND to_regclass(quote_ident(table_name)) IS NOT NULL
+	ORDER BY created_at, table_name`
+	rows, err := r.db.Query(fmt.Sprintf(query, c.ImportsTable), c.ImportDelivered, c.ImportFailed)
+	if e, ok := err.(*pq.Error); ok && e.Code == undefinedTable {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var imports []Import
+	for rows.Next() {
+		var i Import
+		if err := rows.Scan(&i.Table, &i.Source, &i.Rows, &i.Status, &i.Weight, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		if i.Weight < 1 {
+			i.Weight = c.ImportWeight
+		}
+		imports = append(imports, i)
+	}
+	return imports, rows.Err()
+}
+
+// SetDelivered Every row of an imported table has been sent. An import
+// started again meanwhile is left as it is. It returns whether the
+// import has been updated.
+func (r *Registry) SetDelivered(table string) (bool, error) {
+	query := `
+	UPDATE %s
+	SET status = $2, updated_at = NOW()
+	WHERE table_name = $1 AND status = $3`
+	res, err := r.db.Exec(fmt.Sprintf(query, c.ImportsTable), table, c.ImportDelivered, c.ImportImported)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
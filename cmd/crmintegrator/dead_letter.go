@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/database"
+	"github.com/josesolana/csv-reader/config"
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// deadLetterCmd Subcommand name.
+const deadLetterCmd = "deadletter"
+
+const deadLetterUsage = `Usage:
+  crmintegrator deadletter list <table>
+  crmintegrator deadletter show <table> <id>
+  crmintegrator deadletter requeue <table> [id...]
+  crmintegrator deadletter purge <table> [id...]
+
+requeue and purge take every dead letter of the table if no id is given.
+purge deletes the rows too.`
+
+// runDeadLetter Run a deadletter subcommand: list, show, requeue or purge.
+func runDeadLetter(args []string, cfg *config.Config, out io.Writer) error {
+	if len(args) < 2 {
+		return errors.New(deadLetterUsage)
+	}
+	action, table := args[0], args[1]
+	ids, err := parseIDs(args[2:])
+	if err != nil {
+		return err
+	}
+	if action == "show" && len(ids) != 1 {
+		return errors.New(deadLetterUsage)
+	}
+
+	dl := database.NewDeadLetters(cfg)
+	defer dl.Close()
+
+	switch action {
+	case "list":
+		letters, err := dl.List(table)
+		if err != nil {
+			return err
+		}
+		printDeadLetters(out, letters)
+	case "show":
+		letter, row, err := dl.Show(table, ids[0])
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%s: %s %d", c.ErrDeadLetterNotFound, table, ids[0])
+		} else if err != nil {
+			return err
+		}
+		printDeadLetter(out, letter, row)
+	case "requeue":
+		n, err := dl.Requeue(table, ids)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d rows requeued\n", n)
+	case "purge":
+		n, err := dl.Purge(table, ids)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d rows purged\n", n)
+	default:
+		return errors.New(deadLetterUsage)
+	}
+	return nil
+}
+
+func parseIDs(args []string) ([]int, error) {
+	ids := make([]int, len(args))
+	for i, a := range args {
+		id, err := strconv.Atoi(a)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q", c.ErrDeadLetterID, a)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func printDeadLetters(out io.Writer, letters []database.DeadLetter) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tRETRY\tSTATUS\tERROR\tCREATED\tUPDATED")
+	for _, l := range letters {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\n",
+			l.ID, l.Retry, status(l.Status), oneLine(l.Error, 60),
+			l.CreatedAt.Format(time.RFC3339), l.UpdatedAt.Format(time.RFC3339))
+	}
+	w.Flush()
+}
+
+func printDeadLetter(out io.Writer, l *database.DeadLetter, row map[string]string) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Table:\t%s\n", l.Table)
+	fmt.Fprintf(w, "ID:\t%d\n", l.ID)
+	fmt.Fprintf(w, "Retry:\t%d\n", l.Retry)
+	fmt.Fprintf(w, "Status:\t%s\n", status(l.Status))
+	fmt.Fprintf(w, "Error:\t%s\n", l.Error)
+	fmt.Fprintf(w, "Created:\t%s\n", l.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Updated:\t%s\n", l.UpdatedAt.Format(time.RFC3339))
+	fmt.Fprintln(w, "Row:\t")
+	cols := make([]string, 0, len(row))
+	for col := range row {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	for _, col := range cols {
+		fmt.Fprintf(w, "  %s:\t%s\n", col, row[col])
+	}
+	w.Flush()
+	fmt.Fprintf(out, "Response:\n%s\n", l.Response)
+}
+
+func status(s int) string {
+	if s == 0 {
+		return "-"
+	}
+	return strconv.Itoa(s)
+}
+
+// oneLine Cut s to n runes in one line, to fit into a table.
+func oneLine(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-3]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,39 @@
+package integrator
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// gate Holds every worker's request until the CRM's Retry-After.
+type gate struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// delay No request is made for d.
+func (g *gate) delay(d time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if until := time.Now().Add(d); until.After(g.until) {
+		g.until = until
+	}
+}
+
+// wait Wait until the gate is open or ctx is done.
+func (g *gate) wait(ctx context.Context) error {
+	for {
+		g.mu.Lock()
+		d := time.Until(g.until)
+		g.mu.Unlock()
+		if d <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
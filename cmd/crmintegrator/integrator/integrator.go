@@ -11,8 +11,11 @@ import (
 
 	"github.com/jpillora/backoff"
 
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/crm"
 	"github.com/josesolana/csv-reader/cmd/crmintegrator/database"
+	"github.com/josesolana/csv-reader/config"
 	c "github.com/josesolana/csv-reader/constants"
+	"github.com/josesolana/csv-reader/ipc"
 )
 
 func init() {
@@ -21,36 +24,114 @@ func init() {
 
 var errGotSign = errors.New(c.ErrGotSignal)
 var errFailWork = errors.New(c.ErrFailureWorker)
+var errDelivered = errors.New(c.ErrFileDelivered)
 
-// Integrator Reads from DB and send info to JSON CRM API
+// Integrator Reads from DB and send info to JSON CRM API.
+// Its workers are shared by every table it sends.
 type Integrator struct {
 	poolWorker     []*worker
 	runningWorkers *sync.WaitGroup
 	jobs           *sync.WaitGroup
-	db             database.DB
+	sources        []*source
+	cfg            *config.Config
+	crm            crm.Sender
+	breaker        *crm.Breaker
+	batch          *crm.Batch
+	gate           *gate
+	backoff        *backoff.Backoff
 	quitCh         chan interface{}
 	workerFailCh   chan error
 	close          chan os.Signal
+	// conn Connection shared by every table & the registry.
+	conn *database.Conn
+	// registry Imports followed by a daemon. nil for a single table.
+	registry *database.Registry
+	// failed Status of the imports a daemon cannot send, e.g. their
+	// mapping doesn't fit. They are tried again once the status changes.
+	failed map[string]string
+	// wake Receives when rows of any table may be waiting.
+	wake chan struct{}
+	// ipc csvreader which imports the table. nil if there is none.
+	ipc *ipc.Conn
+	// followed Table imported by the csvreader.
+	followed *source
+	// ipcMu Guards eof & disconnected, set as csvreader's messages are
+	// received.
+	ipcMu sync.Mutex
+	// eof csvreader has inserted the whole file.
+	eof bool
+	// disconnected csvreader has closed the connection.
+	disconnected bool
 }
 
-// NewIntegrator Factory pattern
-func NewIntegrator(name string, close chan os.Signal) *Integrator {
+// NewIntegrator Factory pattern. Rows of a table are sent through sender.
+func NewIntegrator(name string, close chan os.Signal, cfg *config.Config, sender crm.Sender) *Integrator {
+	i := newIntegrator(close, cfg, sender)
+	if _, err := i.addSource(name, c.ImportWeight); err != nil {
+		log.Fatalf("Cannot send rows of table %s. Error: %s\n", name, err)
+	}
+	return i
+}
+
+// NewDaemon Factory pattern. Rows of every import into the registry are
+// sent through sender, until they are delivered. Tables share the
+// workers by their weights.
+func NewDaemon(close chan os.Signal, cfg *config.Config, sender crm.Sender) *Integrator {
+	i := newIntegrator(close, cfg, sender)
+	i.registry = database.NewRegistry(i.conn)
+	i.failed = make(map[string]string)
+	return i
+}
+
+func newIntegrator(close chan os.Signal, cfg *config.Config, sender crm.Sender) *Integrator {
 	i := &Integrator{
 		runningWorkers: new(sync.WaitGroup),
 		jobs:           new(sync.WaitGroup),
-		db:             database.NewDB(name),
+		wake:           make(chan struct{}, 1),
+		cfg:            cfg,
+		crm:            sender,
+		batch:          crm.NewBatch(cfg),
+		gate:           new(gate),
+		backoff:        newRetryBackoff(cfg),
 		quitCh:         make(chan interface{}),
 		workerFailCh:   make(chan error),
 		close:          close,
+		conn:           database.NewConn(cfg.Database),
 	}
 
-	i.createPoolWorker(name)
+	if cfg.BreakerFailures > 0 {
+		i.breaker = crm.NewBreaker(sender, cfg.CRMUrl, cfg.BreakerFailures, cfg.BreakerCooldown)
+		i.crm = i.breaker
+	}
+
+	i.createPoolWorker()
 	return i
 }
 
-// Migrate Reads from DB and send info to JSON CRM API
+// newRetryBackoff Delay before a failed row is sent again.
+func newRetryBackoff(cfg *config.Config) *backoff.Backoff {
+	return &backoff.Backoff{
+		Min:    cfg.RetryMin,
+		Max:    cfg.RetryMax,
+		Factor: cfg.RetryFactor,
+		Jitter: cfg.RetryJitter,
+	}
+}
+
+// Migrate Reads from DB and send info to JSON CRM API.
+// It polls by a backoff while there are no rows, but it wakes up at once
+// when csvreader notifies new rows. Following a csvreader, it returns once
+// the whole file has been delivered. A daemon reads the registry every
+// c.RegistryPoll.
 func (i *Integrator) Migrate() {
 	var sleep time.Duration
+	var registry <-chan time.Time
+	if i.registry != nil {
+		i.refresh()
+		ticker := time.NewTicker(c.RegistryPoll)
+		defer ticker.Stop()
+		registry = ticker.C
+	}
 	backOff := &backoff.Backoff{
 		// Min value to retry, if DB is down(It starts at Min)
 		Min: 10 * time.Second,
@@ -72,9 +153,20 @@ func (i *Integrator) Migrate() {
 			log.Printf("Got signal: %s\n", s.String())
 			i.finish()
 			return
+		case <-i.wake:
+			if sleep > 0 {
+				log.Println("New rows have been notified")
+			}
+			backOff.Reset()
+			sleep = 0
+		case <-registry:
+			if i.refresh() {
+				backOff.Reset()
+				sleep = 0
+			}
 		case <-time.After(sleep):
 			if err := i.processRows(&sleep, backOff); err != nil {
-				if err == errGotSign {
+				if err == errGotSign || err == errDelivered {
 					return
 				}
 				log.Fatalln(err)
@@ -85,120 +177,181 @@ func (i *Integrator) Migrate() {
 
 // processRows Process a row batch.
 //
-// - Start a transaction for Select for Update.
+// - Wait while the CRM circuit breaker is open.
+//
+// - Claim batches of rows of every table, up to its weight, which are
+// leased to this integrator until Config.LeaseTime. No transaction is held
+// while rows are sent.
 //
-// - Read from DB if has rows, otherwise wait for new rows.
+// - Randomly balance load to between Workers, shared by every table.
 //
-// - Randomly balance load to between Workers.
+// - Every row is finalized by its worker: set as processed, retried or
+// dead letter. Rows which weren't finalized are released on close or
+// claimed again once their lease expires, also by another integrator.
 //
-// - Commit when every jobs has been Done.
-//	 Only those rows whose has been correctly read will be updated, because
-//	 a the waitgroup increase only if a row was correctly send to a worker.
-//	 If fails in a worker's job, Retry Column became Retry+1
+// - Report the progress to csvreader, if any.
 //
-// - Return error if, and only if, a commit cannot be executed.
+// - Return error if, and only if, the integrator has been stopped, or the
+// file followed has been delivered.
 func (i *Integrator) processRows(sleep *time.Duration, bo *backoff.Backoff) error {
-	if err := i.db.Begin(); err != nil {
-		log.Println("Cannot Begin a transaction")
-		return nil
-	}
-
-	rows, err := i.db.Read()
-	if err != nil {
-		if err != sql.ErrNoRows {
-			log.Println("No more Data. Waiting...")
-		} else {
-			log.Println("Cannot read from DB: ", err)
+	if i.breaker != nil {
+		if d := i.breaker.Ready(); d > 0 {
+			log.Printf("CRM circuit breaker is %s. Waiting %s\n", i.breaker.State(), d)
+			*sleep = d
+			return nil
 		}
-		*sleep = bo.Duration()
-		log.Println("Sleeping by BackOff ", sleep)
-		return nil
 	}
 
-	errBL := i.balanceLoad(rows)
+	n, errBL := i.dispatch()
 	if errBL == errFailWork || errBL == errGotSign {
-		//Try to commit finalized work.
-		if err := i.finishCommit(); err != nil {
-			log.Println("Cannot Commit a transaction")
-			return err
-		}
+		i.finish()
 		return errBL
 	}
 
 	log.Println("Waiting to jobs being done by workers")
 	i.jobs.Wait()
 
-	if err := i.db.Commit(); err != nil {
-		log.Println("Cannot Commit a transaction")
-		return err
-	}
 	if errBL != nil {
-		log.Println("Cannot Read correctly from DB. Error: ", err)
+		log.Println("Cannot Read correctly from DB. Error: ", errBL)
 		*sleep = bo.Duration()
 		log.Println("Sleeping by BackOff ", sleep)
 		return nil
 	}
+	if i.ipc != nil && i.report() {
+		log.Println("Every row of the file has been delivered")
+		i.finish()
+		return errDelivered
+	}
+	if n == 0 {
+		log.Println("No more Data. Waiting...")
+		*sleep = bo.Duration()
+		return nil
+	}
+	bo.Reset()
 	*sleep = 0
 	return nil
 }
 
-func (i *Integrator) balanceLoad(rows *sql.Rows) error {
-	for {
+// dispatch Claim rows of every table & send them to the workers. A table
+// gets up to its weight batches every round, so tables share the workers
+// by their weights. A table which cannot be read is skipped until the
+// next round. It returns how many rows were sent, and the last error if
+// none was.
+func (i *Integrator) dispatch() (int, error) {
+	total := 0
+	var last error
+	for _, s := range i.sources {
+		for b := 0; b < s.weight; b++ {
+			rows, err := s.db.Claim()
+			if err != nil {
+				log.Printf("Cannot read table %s from DB. Error: %s\n", s.table, err)
+				last = err
+				break
+			}
+			n, err := i.balanceLoad(s, rows)
+			rows.Close()
+			total += n
+			if err == errFailWork || err == errGotSign {
+				return total, err
+			}
+			if err != nil {
+				log.Printf("Cannot read table %s from DB. Error: %s\n", s.table, err)
+				last = err
+				break
+			}
+			if n < i.cfg.BatchSizeRow {
+				// Nothing else is waiting.
+				break
+			}
+		}
+	}
+	if total > 0 {
+		return total, nil
+	}
+	return total, last
+}
+
+// balanceLoad Send rows of a table to the workers, grouped by
+// crm_batch_size, so every batch is sent by one worker. It returns how
+// many were sent. Rows read but not sent yet are released on close.
+func (i *Integrator) balanceLoad(s *source, rows *sql.Rows) (int, error) {
+	var group [][]interface{}
+	for n := 0; ; {
 		select {
 		case err := <-i.workerFailCh:
 			log.Println("A worker got a failure: ", err)
-			return errFailWork
+			return n, errFailWork
 		case s := <-i.close:
 			log.Printf("Got signal: %s\n", s.String())
-			return errGotSign
+			return n, errGotSign
 		default:
-			if rows.Next() {
-				vals, err := i.createScanSlice(rows)
-				if err != nil {
-					return err
+			if !rows.Next() {
+				if len(group) > 0 {
+					i.send(s, group)
+					n += len(group)
 				}
-				if err := rows.Scan(vals...); err != nil {
-					log.Printf("Cannot retrieve info from DB. Error: %s\n", err)
-					return err
-				}
-				w := *(vals[c.IDPos]).(*int) % c.Workers
-				i.poolWorker[w].sourceCh <- vals
-				i.jobs.Add(1)
-			} else {
-				return nil
+				return n, rows.Err()
+			}
+			vals, err := i.createScanSlice(rows)
+			if err != nil {
+				return n, err
+			}
+			if err := rows.Scan(vals...); err != nil {
+				log.Printf("Cannot retrieve info from DB. Error: %s\n", err)
+				return n, err
+			}
+			group = append(group, vals)
+			if len(group) >= i.cfg.CRMBatchSize {
+				i.send(s, group)
+				n += len(group)
+				group = nil
 			}
 		}
 	}
 }
 
+// send Hand rows to the worker of the first one.
+func (i *Integrator) send(s *source, rows [][]interface{}) {
+	w := *(rows[0][c.IDPos]).(*int) % i.cfg.Workers
+	// Added before the worker could be done with them.
+	i.jobs.Add(len(rows))
+	i.poolWorker[w].sourceCh <- job{src: s, rows: rows}
+}
+
 func (i *Integrator) createScanSlice(rows *sql.Rows) ([]interface{}, error) {
 	cols, err := rows.Columns()
 	if err != nil {
 		return nil, err
 	}
 
+	// Values are copied: rows are sent after the next ones are read.
 	vals := make([]interface{}, len(cols))
 	for i := 0; i < len(cols); i++ {
-		vals[i] = new(sql.RawBytes)
+		vals[i] = new([]byte)
 	}
 	vals[c.IDPos] = new(int)
 	vals[c.IsProcessedPos] = new(bool)
 	vals[c.RetryPos] = new(int)
+	vals[c.IdempotencyKeyPos] = new(sql.NullString)
 	return vals, nil
 }
 
 // createPoolWorker Create a workers's slice.
-// There are go routines as workers set in constants.Workers.
+// There are go routines as workers set in Config.Workers.
 // Workers are a channel to a function which do the job.
-func (i *Integrator) createPoolWorker(name string) {
-	log.Printf("Starting %d Workers\n", c.Workers)
-	i.runningWorkers.Add(c.Workers)
-	workers := make([]*worker, c.Workers)
+func (i *Integrator) createPoolWorker() {
+	log.Printf("Starting %d Workers\n", i.cfg.Workers)
+	i.runningWorkers.Add(i.cfg.Workers)
+	workers := make([]*worker, i.cfg.Workers)
 	for index, _ := range workers {
 		w := worker{
-			sourceCh: make(chan []interface{}, c.Buff),
+			sourceCh: make(chan job, i.cfg.Buff),
+			cfg:      i.cfg,
+			crm:      i.crm,
+			batch:    i.batch,
+			gate:     i.gate,
+			backoff:  i.backoff,
 			quitCh:   i.quitCh,
-			db:       &i.db,
 			errorCh:  i.workerFailCh,
 		}
 		w.Start(i.runningWorkers, i.jobs)
@@ -213,29 +366,19 @@ func (i *Integrator) finish() {
 	close(i.quitCh)
 	log.Println("Waiting for finish workers")
 	i.runningWorkers.Wait()
-	log.Println("Closing DB")
-	if err := i.db.Close(); len(err) != 0 {
-		log.Fatalln(err)
+	if i.ipc != nil {
+		i.ipc.Close()
 	}
-	log.Println("Everythings has been closed")
-}
-
-func (i *Integrator) finishCommit() error {
-	log.Println("Send close Broadcast")
-	close(i.quitCh)
-	log.Println("Waiting for finish workers")
-	i.runningWorkers.Wait()
-
-	log.Println("Forced Commit")
-	if err := i.db.Commit(); err != nil {
-		log.Println("Cannot Commit a transaction")
-		return err
+	log.Println("Closing DB. Unfinished rows are released")
+	var errs []error
+	for len(i.sources) > 0 {
+		errs = append(errs, i.removeSource(i.sources[0])...)
 	}
-
-	log.Println("Closing DB")
-	if err := i.db.Close(); len(err) != 0 {
-		log.Fatalln(err)
+	if err := i.conn.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) != 0 {
+		log.Fatalln(errs)
 	}
 	log.Println("Everythings has been closed")
-	return nil
 }
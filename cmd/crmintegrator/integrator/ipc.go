@@ -0,0 +1,102 @@
+package integrator
+
+import (
+	"io"
+	"log"
+
+	c "github.com/josesolana/csv-reader/constants"
+	"github.com/josesolana/csv-reader/ipc"
+)
+
+// SetIPC Follow the csvreader connected by conn: the rows it inserts wake
+// the integrator up, the progress is reported back to it, and Migrate
+// returns once its file has been delivered. It should be called before
+// Migrate, on an integrator of a single table.
+func (i *Integrator) SetIPC(conn *ipc.Conn) {
+	i.ipc = conn
+	i.followed = i.sources[0]
+	go i.receive()
+}
+
+// receive Read csvreader's messages until it disconnects. They are read
+// as soon as they arrive, so csvreader never waits for the integrator:
+// messages only set flags & wake it up.
+func (i *Integrator) receive() {
+	for {
+		m, err := i.ipc.Receive()
+		if err != nil {
+			select {
+			case <-i.quitCh:
+				return
+			default:
+			}
+			if err != io.EOF {
+				log.Printf("Cannot read from csvreader. Error: %s\n", err)
+			}
+			log.Println("csvreader has disconnected. The table is polled")
+			i.ipcMu.Lock()
+			i.disconnected = true
+			i.ipcMu.Unlock()
+			return
+		}
+		if i.handle(m) {
+			select {
+			case i.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// handle A message from csvreader. It returns whether rows are waiting.
+func (i *Integrator) handle(m ipc.Message) bool {
+	if m.Table != i.followed.table {
+		log.Printf("Ignored %s message of table %s\n", m.Type, m.Table)
+		return false
+	}
+	switch m.Type {
+	case c.IPCRows:
+		log.Printf("csvreader has inserted %d rows, lines %d to %d\n", m.Rows, m.From, m.To)
+		return true
+	case c.IPCEOF:
+		log.Printf("csvreader has inserted the whole file, %d rows\n", m.Rows)
+		i.ipcMu.Lock()
+		i.eof = true
+		i.ipcMu.Unlock()
+		return true
+	}
+	return false
+}
+
+// report Send the progress to csvreader, while it's connected. It returns
+// whether the whole file has been delivered: csvreader has reached its
+// end and no row is pending. Dead letters are delivered as well.
+func (i *Integrator) report() bool {
+	p, err := i.followed.db.Progress()
+	if err != nil {
+		log.Printf("Cannot count the rows sent. Error: %s\n", err)
+		return false
+	}
+	i.ipcMu.Lock()
+	done := i.eof && p.Pending == 0
+	disconnected := i.disconnected
+	i.ipcMu.Unlock()
+	if disconnected {
+		return done
+	}
+
+	m := ipc.Message{
+		Type:        c.IPCProgress,
+		Table:       i.followed.table,
+		Processed:   p.Processed,
+		DeadLetters: p.DeadLetters,
+		Pending:     p.Pending,
+	}
+	if done {
+		m.Type = c.IPCDone
+	}
+	if err := i.ipc.Send(m); err != nil {
+		log.Printf("Cannot report the progress to csvreader. Error: %s\n", err)
+	}
+	return done
+}
@@ -0,0 +1,161 @@
+package integrator
+
+import (
+	"log"
+
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/crm"
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/database"
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// source A table whose rows are sent by the workers.
+type source struct {
+	table   string
+	db      database.DB
+	mapping *crm.Mapping
+	// weight Batches claimed from the table every round.
+	weight int
+	// imported csvreader has inserted the whole file.
+	imported bool
+	stop     chan struct{}
+}
+
+// job Rows claimed from a source, sent at once if crm_batch_size is
+// above 1. Otherwise, a single row.
+type job struct {
+	src  *source
+	rows [][]interface{}
+}
+
+// addSource Start sending the rows of a table. Its notifications wake the
+// integrator up.
+func (i *Integrator) addSource(table string, weight int) (*source, error) {
+	db, err := database.NewDBWithConn(table, i.cfg, i.conn)
+	if err != nil {
+		return nil, err
+	}
+	s := &source{
+		table:  table,
+		db:     db,
+		weight: weight,
+		stop:   make(chan struct{}),
+	}
+	if i.cfg.CRMMapping != "" {
+		m, err := crm.LoadMapping(i.cfg.CRMMapping, s.db.Columns())
+		if err != nil {
+			s.db.Close()
+			return nil, err
+		}
+		s.mapping = m
+	}
+
+	go func() {
+		for {
+			select {
+			case <-s.db.Notified():
+				select {
+				case i.wake <- struct{}{}:
+				default:
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	i.sources = append(i.sources, s)
+	log.Printf("Sending rows of table %s, weight %d\n", table, weight)
+	return s, nil
+}
+
+// removeSource Stop sending the rows of a table. Rows claimed and not
+// finalized are released. No job of it should be running.
+func (i *Integrator) removeSource(s *source) []error {
+	for n, src := range i.sources {
+		if src == s {
+			i.sources = append(i.sources[:n], i.sources[n+1:]...)
+			break
+		}
+	}
+	close(s.stop)
+	log.Printf("Table %s is not sent anymore\n", s.table)
+	return s.db.Close()
+}
+
+// refresh Follow the registry: imports which are new are added, imports
+// which aren't active anymore are removed and imported tables without
+// pending rows are delivered. An import which cannot be sent is not tried
+// again until its status changes. It returns whether a table has been
+// added.
+func (i *Integrator) refresh() bool {
+	imports, err := i.registry.Active()
+	if err != nil {
+		log.Printf("Cannot read the registry. Error: %s\n", err)
+		return false
+	}
+	added := false
+
+	active := make(map[string]database.Import, len(imports))
+	for _, imp := range imports {
+		active[imp.Table] = imp
+	}
+	for _, s := range append([]*source(nil), i.sources...) {
+		imp, ok := active[s.table]
+		if !ok {
+			i.removeSource(s)
+			continue
+		}
+		s.weight = imp.Weight
+		s.imported = imp.Status == c.ImportImported
+		delete(active, s.table)
+	}
+	for table, status := range i.failed {
+		if imp, ok := active[table]; !ok || imp.Status != status {
+			delete(i.failed, table)
+		}
+	}
+	for _, imp := range imports {
+		if _, ok := active[imp.Table]; !ok {
+			continue
+		}
+		if _, ok := i.failed[imp.Table]; ok {
+			continue
+		}
+		s, err := i.addSource(imp.Table, imp.Weight)
+		if err != nil {
+			log.Printf("Cannot send rows of table %s while it's %s. Error: %s\n", imp.Table, imp.Status, err)
+			i.failed[imp.Table] = imp.Status
+			continue
+		}
+		s.imported = imp.Status == c.ImportImported
+		added = true
+	}
+
+	for _, s := range append([]*source(nil), i.sources...) {
+		if s.imported {
+			i.deliver(s)
+		}
+	}
+	return added
+}
+
+// deliver Set an imported table as delivered, if none of its rows is
+// pending, and stop sending it.
+func (i *Integrator) deliver(s *source) {
+	p, err := s.db.Progress()
+	if err != nil {
+		log.Printf("Cannot count the rows of table %s. Error: %s\n", s.table, err)
+		return
+	}
+	if p.Pending > 0 {
+		return
+	}
+	ok, err := i.registry.SetDelivered(s.table)
+	if err != nil {
+		log.Printf("Cannot set table %s as delivered. Error: %s\n", s.table, err)
+		return
+	}
+	if ok {
+		log.Printf("Table %s has been delivered: %d rows processed & %d dead letters\n", s.table, p.Processed, p.DeadLetters)
+		i.removeSource(s)
+	}
+}
@@ -1,30 +1,43 @@
 package integrator
 
 import (
-	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/jpillora/backoff"
+
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/crm"
 	"github.com/josesolana/csv-reader/cmd/crmintegrator/database"
+	"github.com/josesolana/csv-reader/config"
 	c "github.com/josesolana/csv-reader/constants"
 )
 
-func init() {
-	rand.Seed(time.Now().UTC().UnixNano())
-}
-
 type worker struct {
-	sourceCh chan []interface{}
+	sourceCh chan job
 	quitCh   chan interface{}
 	errorCh  chan error
-	db       *database.DB
+	cfg      *config.Config
+	crm      crm.Sender
+	batch    *crm.Batch
+	gate     *gate
 	cancel   context.CancelFunc
 	cx       *context.Context
+	// backoff Delay before a failed row is sent again. Only ForAttempt is
+	// used, so it's shared by every worker.
+	backoff *backoff.Backoff
+}
+
+// row A row ready to be sent.
+type row struct {
+	id, retry int
+	src       *source
+	req       crm.Request
 }
 
 func (w *worker) Start(runningWorkers, jobs *sync.WaitGroup) {
@@ -33,16 +46,12 @@ func (w *worker) Start(runningWorkers, jobs *sync.WaitGroup) {
 	w.cancel = cancel
 
 	go func() {
-		var err error
 		for {
 			select {
-			case vals := <-w.sourceCh:
-				err = w.makeRequest(vals)
-				jobs.Done()
-				if err != nil {
-					w.errorCh <- err
-				}
+			case j := <-w.sourceCh:
+				w.send(jobs, j)
 			case <-w.quitCh:
+				// Rows not sent yet are released by the integrator.
 				w.cancel()
 				runningWorkers.Done()
 				return
@@ -51,56 +60,214 @@ func (w *worker) Start(runningWorkers, jobs *sync.WaitGroup) {
 	}()
 }
 
-func (w *worker) makeRequest(vals []interface{}) error {
-	id := *vals[c.IDPos].(*int)
-	httpClient := http.Client{
-		Timeout: c.TimeOut,
+// send Prepare the rows of a job & send them. A failure stops the job:
+// rows not sent yet are released by the integrator.
+func (w *worker) send(jobs *sync.WaitGroup, j job) {
+	var batch []*row
+	for n, vals := range j.rows {
+		r, err := w.prepare(j.src, vals)
+		if err != nil {
+			w.done(jobs, len(j.rows)-n+len(batch), err)
+			return
+		}
+		if r == nil {
+			w.done(jobs, 1, nil)
+			continue
+		}
+		batch = append(batch, r)
 	}
-	postReq, err := w.preparePostRequest(vals)
+	if len(batch) > 0 {
+		w.done(jobs, len(batch), w.flush(batch))
+	}
+}
 
+func (w *worker) done(jobs *sync.WaitGroup, n int, err error) {
+	jobs.Add(-n)
 	if err != nil {
-		log.Println("Fail creating POST request", err)
-		return (*w.db).IncreaseRetry(id)
+		w.errorCh <- err
 	}
+}
+
+// prepare Build the request of a row. A row which cannot be serialized
+// is set as dead letter & nil is returned.
+func (w *worker) prepare(src *source, vals []interface{}) (*row, error) {
+	db := src.db
+	id := *vals[c.IDPos].(*int)
+	retry := *vals[c.RetryPos].(*int)
 
-	resp, err := httpClient.Do(postReq)
+	// Skipped those values whom has been added to handle row flow.
+	payload, err := w.payload(src, vals[c.DataPos:])
+	if err != nil {
+		log.Printf("Cannot map a row. ID: %d. Error: %s\n", id, err)
+		return nil, db.SetAsDeadLetter(id, database.Failure{Error: err.Error()})
+	}
+	body, err := json.Marshal(payload)
 	if err != nil {
-		log.Println("Cannot make a request to JSON API")
-		return (*w.db).IncreaseRetry(id)
+		log.Printf("Cannot serialize a row. ID: %d. Error: %s\n", id, err)
+		return nil, db.SetAsDeadLetter(id, database.Failure{Error: err.Error()})
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode > http.StatusBadRequest {
-		log.Println("JSON API response a Bad Request")
-		return (*w.db).IncreaseRetry(id)
+	// The key is saved before the first attempt, so a row whose response
+	// has been lost is sent again with the same key, even after a restart.
+	key, err := w.idempotencyKey(src, id, vals[c.IdempotencyKeyPos].(*sql.NullString), body)
+	if err != nil {
+		log.Printf("Cannot save the idempotency key. ID: %d. Error: %s\n", id, err)
+		return nil, err
+	}
+	if obj, ok := payload.(map[string]interface{}); ok && w.cfg.IdempotencyField != "" {
+		obj[w.cfg.IdempotencyField] = key
+		if body, err = json.Marshal(obj); err != nil {
+			return nil, db.SetAsDeadLetter(id, database.Failure{Error: err.Error()})
+		}
 	}
+	return &row{id: id, retry: retry, src: src, req: crm.Request{ID: id, Body: body, IdempotencyKey: key}}, nil
+}
 
-	return (*w.db).SetAsProcessed(id)
+// flush Send rows, one by one or as a batch if crm_batch_size is set.
+func (w *worker) flush(rows []*row) error {
+	if err := w.gate.wait(*w.cx); err != nil {
+		// Stopped before rows have been sent. They're read again later.
+		return nil
+	}
+	if w.cfg.CRMBatchSize <= 1 {
+		for _, r := range rows {
+			if err := w.makeRequest(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return w.makeBatchRequest(rows)
 }
 
-func (w *worker) preparePostRequest(vals []interface{}) (*http.Request, error) {
-	var url string
+// makeRequest Send a row. If the circuit breaker is open, the row is
+// released as it is, so its retries are untouched.
+func (w *worker) makeRequest(r *row) error {
+	resp, err := w.crm.Send(*w.cx, r.req)
+	if err == crm.ErrCircuitOpen {
+		return r.src.db.Release(r.id)
+	}
+	return w.finalize(r, crm.Classify(resp, err), failure(resp, err), w.retryAfter(resp))
+}
 
-	// Skipped those values whom has been added to handle row flow.
-	body, err := json.Marshal(vals[3:])
-	if err != nil {
-		log.Printf("Cannot serialize a row. ID: %d. Error: %s\n", vals[c.IDPos], err)
-		return nil, err
+// makeBatchRequest Send rows of a table as one request. Every row is finalized by its
+// own result, unless the whole request failed.
+func (w *worker) makeBatchRequest(rows []*row) error {
+	records := make([]crm.Request, len(rows))
+	for i, r := range rows {
+		records[i] = r.req
+	}
+	var resp *crm.Response
+	req, err := w.batch.Request(records)
+	if err == nil {
+		resp, err = w.crm.Send(*w.cx, req)
 	}
+	if err == crm.ErrCircuitOpen {
+		ids := make([]int, len(rows))
+		for i, r := range rows {
+			ids[i] = r.id
+		}
+		return rows[0].src.db.Release(ids...)
+	}
+	retryAfter := w.retryAfter(resp)
+
+	outcome := crm.Classify(resp, err)
+	var results []crm.Result
+	if outcome == crm.Success {
+		if results, err = w.batch.Split(resp, records); err != nil {
+			log.Printf("Cannot read the batch response. Error: %s\n", err)
+			outcome = crm.Retryable
+		}
+	}
+
+	for i, r := range rows {
+		o, f := outcome, failure(resp, err)
+		if results != nil {
+			o = results[i].Outcome
+			f = database.Failure{Status: results[i].Status, Error: results[i].Error, Response: string(results[i].Body)}
+		}
+		if err := w.finalize(r, o, f, retryAfter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retryAfter Delay asked by the CRM, if any. Every worker's requests are
+// held until then.
+func (w *worker) retryAfter(resp *crm.Response) time.Duration {
+	d, ok := crm.RetryAfter(resp, time.Now())
+	if ok {
+		log.Printf("JSON API asks to retry after %s\n", d)
+		w.gate.delay(d)
+	}
+	return d
+}
+
+// finalize Update a row by its outcome. A retried row waits for its
+// backoff or retryAfter, whatever is longer.
+func (w *worker) finalize(r *row, o crm.Outcome, f database.Failure, retryAfter time.Duration) error {
+	switch o {
+	case crm.Success:
+		return r.src.db.SetAsProcessed(r.id)
+	case crm.Retryable:
+		after := w.backoff.ForAttempt(float64(r.retry))
+		if retryAfter > after {
+			after = retryAfter
+		}
+		log.Printf("JSON API request failed. ID: %d. Retry in %s. Error: %s\n", r.id, after, f.Error)
+		return r.src.db.IncreaseRetry(r.id, after, f)
+	}
+	log.Printf("JSON API rejected a row. ID: %d. Error: %s\n", r.id, f.Error)
+	return r.src.db.SetAsDeadLetter(r.id, f)
+}
 
-	//Fail rate 60%
-	if rand.Intn(100) > 40 {
-		url = c.CRMUrlFail
-	} else {
-		url = c.CRMUrl
+// idempotencyKey The row's saved key, or a new one which is saved.
+func (w *worker) idempotencyKey(src *source, id int, saved *sql.NullString, body []byte) (string, error) {
+	if saved.Valid {
+		return saved.String, nil
 	}
+	return src.db.SetIdempotencyKey(id, crm.IdempotencyKey(w.cfg.IdempotencyKey, src.table, id, body))
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+func failure(resp *crm.Response, err error) database.Failure {
 	if err != nil {
-		log.Printf("Cannot make a POST request. ID: %d. Error: %s\n", vals[c.IDPos], err)
-		return nil, err
+		return database.Failure{Error: err.Error()}
+	}
+	return database.Failure{
+		Status:   resp.Status,
+		Error:    fmt.Sprintf("%d %s", resp.Status, http.StatusText(resp.Status)),
+		Response: string(resp.Body),
+	}
+}
+
+// payload The row rendered by the mapping, if any. Otherwise, a JSON
+// object keyed by the columns original names, if they are known, or an
+// array of values.
+func (w *worker) payload(src *source, vals []interface{}) (interface{}, error) {
+	strs := make([]interface{}, len(vals))
+	for i, v := range vals {
+		if b, ok := v.(*[]byte); ok && *b != nil {
+			strs[i] = string(*b)
+		}
+	}
+	names := src.db.Columns()
+	if len(names) != len(vals) {
+		return strs, nil
+	}
+
+	if src.mapping != nil {
+		row := make(map[string]string, len(vals))
+		for i, v := range strs {
+			s, _ := v.(string)
+			row[names[i]] = s
+		}
+		return src.mapping.Render(row)
+	}
+
+	obj := make(map[string]interface{}, len(vals))
+	for i, v := range strs {
+		obj[names[i]] = v
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req = req.WithContext(*w.cx)
-	return req, nil
+	return obj, nil
 }
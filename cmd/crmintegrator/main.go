@@ -1,25 +1,99 @@
 package main
 
 import (
+	_ "expvar"
+	"flag"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
 	_ "github.com/lib/pq"
 
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/crm"
 	"github.com/josesolana/csv-reader/cmd/crmintegrator/integrator"
+	"github.com/josesolana/csv-reader/config"
+	"github.com/josesolana/csv-reader/constants"
+	"github.com/josesolana/csv-reader/ipc"
 )
 
 func main() {
-	if len(os.Args) == 1 {
-		log.Fatalf("Table to be migrated should be provided")
+	loader := config.NewLoader(constants.CRMIntegratorEnv, config.Integrator, flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatalf("Fatal Error: %s\n", err)
+	}
+
+	if flag.Arg(0) == deadLetterCmd {
+		if err := runDeadLetter(flag.Args()[1:], cfg, os.Stdout); err != nil {
+			log.Fatalf("Fatal Error: %s\n", err)
+		}
+		return
+	}
+
+	if cfg.MetricsAddr != "" {
+		go func() {
+			log.Printf("Serving metrics at http://%s/debug/vars\n", cfg.MetricsAddr)
+			log.Println(http.ListenAndServe(cfg.MetricsAddr, nil))
+		}()
+	}
+
+	// Without a table, every import into the registry is sent, unless a
+	// csvreader is awaited.
+	table := flag.Arg(0)
+	var conn *ipc.Conn
+	if table == "" && cfg.IPCSocket != "" {
+		if conn, table, err = waitForTable(cfg.IPCSocket); err != nil {
+			log.Fatalf("Fatal Error: %s\n", err)
+		}
 	}
 
 	// To interrupt the executable
 	runCh := make(chan os.Signal, 1)
 	signal.Notify(runCh, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
-	i := integrator.NewIntegrator(os.Args[1], runCh)
+	sender, err := crm.NewSender(cfg)
+	if err != nil {
+		log.Fatalf("Fatal Error: %s\n", err)
+	}
+
+	var i *integrator.Integrator
+	if table == "" {
+		log.Println("No table given. Sending every import of the registry")
+		i = integrator.NewDaemon(runCh, cfg, sender)
+	} else {
+		i = integrator.NewIntegrator(table, runCh, cfg, sender)
+	}
+	if conn != nil {
+		i.SetIPC(conn)
+	}
 	i.Migrate()
 }
+
+// waitForTable Listen at path until a csvreader announces the table it
+// imports. The connection is kept to follow it.
+func waitForTable(path string) (*ipc.Conn, string, error) {
+	l, err := ipc.Listen(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer l.Close()
+
+	log.Printf("Waiting for csvreader at %s\n", path)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return nil, "", err
+		}
+		m, err := conn.Receive()
+		if err == nil && m.Type == constants.IPCTable && m.Table != "" {
+			log.Printf("csvreader is importing table %s\n", m.Table)
+			return conn, m.Table, nil
+		}
+		log.Printf("csvreader didn't announce a table. Error: %v\n", err)
+		conn.Close()
+	}
+}
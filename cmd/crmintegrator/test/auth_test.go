@@ -0,0 +1,215 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/crm"
+	"github.com/josesolana/csv-reader/config"
+	c "github.com/josesolana/csv-reader/constants"
+	"github.com/stretchr/testify/suite"
+)
+
+type AuthTest struct {
+	suite.Suite
+	mu sync.Mutex
+	// tokens Access tokens handed out by the token server, in order.
+	tokens    []string
+	expiresIn int
+	// valid Token accepted by the CRM. Any other one gets a 401.
+	valid string
+	// auth Authorization header of every CRM request.
+	auth      []string
+	tokenSrv  *httptest.Server
+	crmSrv    *httptest.Server
+	tokenAsks int
+	cfg       *config.Config
+}
+
+func TestAuthController(t *testing.T) {
+	suite.Run(t, new(AuthTest))
+}
+
+func (at *AuthTest) SetupTest() {
+	at.tokens = []string{"t1", "t2", "t3"}
+	at.expiresIn = 3600
+	at.valid = ""
+	at.auth = nil
+	at.tokenAsks = 0
+
+	at.tokenSrv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		at.mu.Lock()
+		token := at.tokens[at.tokenAsks]
+		at.tokenAsks++
+		at.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "` + token + `", "token_type": "bearer", "expires_in": ` + strconv.Itoa(at.expiresIn) + `}`))
+	}))
+	at.crmSrv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		at.mu.Lock()
+		defer at.mu.Unlock()
+		h := r.Header.Get("Authorization")
+		if h == "" {
+			h = r.Header.Get(c.AuthHeader)
+		}
+		at.auth = append(at.auth, h)
+		if at.valid != "" && h != at.valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	at.cfg = config.Default()
+	at.cfg.CRMUrl = at.crmSrv.URL
+	at.cfg.CRMOAuthTokenURL = at.tokenSrv.URL
+	at.cfg.CRMOAuthClientID = "client"
+	at.cfg.CRMOAuthClientSecret = "s3cret"
+}
+
+func (at *AuthTest) TearDownTest() {
+	at.tokenSrv.Close()
+	at.crmSrv.Close()
+}
+
+func (at *AuthTest) send(cl *crm.Client) int {
+	resp, err := cl.Send(context.Background(), crm.Request{ID: 1})
+	at.Require().Nil(err)
+	return resp.Status
+}
+
+func (at *AuthTest) TestStatic() {
+	at.cfg.CRMAuth = c.AuthBearer
+	at.cfg.CRMAuthToken = "abc"
+	cl, err := crm.NewClient(at.cfg)
+	at.Nil(err)
+	at.send(cl)
+
+	at.cfg.CRMAuth = c.AuthAPIKey
+	cl, err = crm.NewClient(at.cfg)
+	at.Nil(err)
+	at.send(cl)
+
+	at.cfg.CRMAuth = c.AuthBasic
+	at.cfg.CRMAuthUser = "fons"
+	at.cfg.CRMAuthPassword = "pass"
+	cl, err = crm.NewClient(at.cfg)
+	at.Nil(err)
+	at.send(cl)
+
+	at.Equal([]string{"Bearer abc", "abc", "Basic Zm9uczpwYXNz"}, at.auth)
+}
+
+func (at *AuthTest) TestOAuth2Cached() {
+	at.cfg.CRMAuth = c.AuthOAuth2
+	cl, err := crm.NewClient(at.cfg)
+	at.Nil(err)
+	at.Equal(http.StatusCreated, at.send(cl))
+	at.Equal(http.StatusCreated, at.send(cl))
+	at.Equal(1, at.tokenAsks)
+	at.Equal([]string{"Bearer t1", "Bearer t1"}, at.auth)
+}
+
+func (at *AuthTest) TestOAuth2Refresh() {
+	now := time.Date(2019, 3, 25, 10, 0, 0, 0, time.UTC)
+	a := &crm.OAuth2{
+		TokenURL:     at.tokenSrv.URL,
+		ClientID:     "client",
+		ClientSecret: "s3cret",
+		HTTP:         http.DefaultClient,
+		Now:          func() time.Time { return now },
+	}
+	token, err := a.Token(context.Background())
+	at.Nil(err)
+	at.Equal("t1", token)
+
+	// Still valid for more than c.TokenRefresh.
+	now = now.Add(time.Hour - c.TokenRefresh - time.Second)
+	token, _ = a.Token(context.Background())
+	at.Equal("t1", token)
+
+	now = now.Add(2 * time.Second)
+	token, _ = a.Token(context.Background())
+	at.Equal("t2", token)
+	at.Equal(2, at.tokenAsks)
+}
+
+func (at *AuthTest) TestOAuth2ReauthOn401() {
+	at.cfg.CRMAuth = c.AuthOAuth2
+	at.valid = "Bearer t2"
+	cl, err := crm.NewClient(at.cfg)
+	at.Nil(err)
+	at.Equal(http.StatusCreated, at.send(cl))
+	at.Equal([]string{"Bearer t1", "Bearer t2"}, at.auth)
+
+	// Only once: a CRM which keeps answering 401 gets two requests.
+	at.valid = "Bearer none"
+	at.auth = nil
+	at.Equal(http.StatusUnauthorized, at.send(cl))
+	at.Equal([]string{"Bearer t2", "Bearer t3"}, at.auth)
+	at.Equal(3, at.tokenAsks)
+}
+
+// TestReauthLimited The request sent again after a 401 waits for a token
+// of the rate limiter, like any other.
+func (at *AuthTest) TestReauthLimited() {
+	at.cfg.CRMAuth = c.AuthOAuth2
+	at.cfg.CRMRate = 10
+	at.valid = "Bearer t2"
+	sender, err := crm.NewSender(at.cfg)
+	at.Nil(err)
+
+	start := time.Now()
+	resp, err := sender.Send(context.Background(), crm.Request{ID: 1})
+	at.Require().Nil(err)
+	at.Equal(http.StatusCreated, resp.Status)
+	at.Equal([]string{"Bearer t1", "Bearer t2"}, at.auth)
+	at.True(time.Since(start) >= 90*time.Millisecond, time.Since(start))
+}
+
+// TestOAuth2InvalidateRenewed A worker rejected with a token which has
+// been renewed meanwhile doesn't make the new one be forgotten.
+func (at *AuthTest) TestOAuth2InvalidateRenewed() {
+	a := &crm.OAuth2{
+		TokenURL:     at.tokenSrv.URL,
+		ClientID:     "client",
+		ClientSecret: "s3cret",
+		HTTP:         http.DefaultClient,
+	}
+	first, _ := http.NewRequest(http.MethodPost, at.crmSrv.URL, nil)
+	second, _ := http.NewRequest(http.MethodPost, at.crmSrv.URL, nil)
+	at.Nil(a.Apply(context.Background(), first))
+	at.Nil(a.Apply(context.Background(), second))
+
+	a.Invalidate(first)
+	token, err := a.Token(context.Background())
+	at.Nil(err)
+	at.Equal("t2", token)
+
+	a.Invalidate(second)
+	token, err = a.Token(context.Background())
+	at.Nil(err)
+	at.Equal("t2", token)
+	at.Equal(2, at.tokenAsks)
+}
+
+func (at *AuthTest) TestOAuth2TokenRejected() {
+	at.cfg.CRMAuth = c.AuthOAuth2
+	at.cfg.CRMOAuthClientSecret = "wrong"
+	cl, err := crm.NewClient(at.cfg)
+	at.Nil(err)
+	resp, err := cl.Send(context.Background(), crm.Request{ID: 1})
+	at.Error(err)
+	at.Equal(crm.Retryable, crm.Classify(resp, err))
+	at.Empty(at.auth)
+}
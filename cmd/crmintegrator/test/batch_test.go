@@ -0,0 +1,82 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/crm"
+	c "github.com/josesolana/csv-reader/constants"
+	"github.com/stretchr/testify/suite"
+)
+
+type BatchTest struct {
+	suite.Suite
+	records []crm.Request
+}
+
+func TestBatchController(t *testing.T) {
+	suite.Run(t, new(BatchTest))
+}
+
+func (bt *BatchTest) SetupTest() {
+	bt.records = []crm.Request{
+		{ID: 1, Body: []byte(`{"name":"Fons"}`), IdempotencyKey: "customers:1"},
+		{ID: 2, Body: []byte(`{"name":"Mal"}`), IdempotencyKey: "customers:2"},
+		{ID: 3, Body: []byte(`{"name":"Zoe"}`), IdempotencyKey: "customers:3"},
+	}
+}
+
+func (bt *BatchTest) response(status int, body string) *crm.Response {
+	return &crm.Response{Status: status, Header: http.Header{}, Body: []byte(body)}
+}
+
+func (bt *BatchTest) TestRequest() {
+	req, err := new(crm.Batch).Request(bt.records)
+	bt.Nil(err)
+	bt.Equal(1, req.ID)
+	bt.Equal(`[{"name":"Fons"},{"name":"Mal"},{"name":"Zoe"}]`, string(req.Body))
+	bt.Empty(req.IdempotencyKey)
+}
+
+func (bt *BatchTest) TestByPosition() {
+	b := &crm.Batch{}
+	results, err := b.Split(bt.response(http.StatusOK, `[{"id": 10}, {"id": 11}]`), bt.records)
+	bt.Nil(err)
+	bt.Len(results, 3)
+	bt.Equal(crm.Success, results[0].Outcome)
+	bt.Equal(http.StatusOK, results[0].Status)
+	bt.Equal(`{"id":10}`, string(results[0].Body))
+	bt.Equal(crm.Success, results[1].Outcome)
+	bt.Equal(crm.Retryable, results[2].Outcome)
+	bt.Equal(c.ErrCRMBatchItem, results[2].Error)
+}
+
+func (bt *BatchTest) TestByID() {
+	b := &crm.Batch{Results: "data.results", ID: "external_id", Status: "status"}
+	resp := bt.response(http.StatusMultiStatus, `{"data": {"results": [
+		{"external_id": "customers:3", "status": 422, "error": "email"},
+		{"external_id": 1, "status": "201"},
+		{"external_id": "customers:2", "status": 503}
+	]}}`)
+	results, err := b.Split(resp, bt.records)
+	bt.Nil(err)
+	bt.Equal(crm.Success, results[0].Outcome)
+	bt.Equal(http.StatusCreated, results[0].Status)
+	bt.Equal(crm.Retryable, results[1].Outcome)
+	bt.Equal(crm.Permanent, results[2].Outcome)
+	bt.Equal("422 Unprocessable Entity", results[2].Error)
+	bt.Contains(string(results[2].Body), `"error":"email"`)
+}
+
+func (bt *BatchTest) TestInvalidResponse() {
+	b := &crm.Batch{Results: "results", Status: "status"}
+	_, err := b.Split(bt.response(http.StatusOK, `{"data": []}`), bt.records)
+	bt.EqualError(err, c.ErrCRMBatchResults)
+
+	_, err = b.Split(bt.response(http.StatusOK, `<html>`), bt.records)
+	bt.Error(err)
+
+	results, err := b.Split(bt.response(http.StatusOK, `{"results": [{"status": "ok"}]}`), bt.records[:1])
+	bt.Nil(err)
+	bt.Equal(crm.Permanent, results[0].Outcome)
+}
@@ -0,0 +1,112 @@
+package test
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/crm"
+	"github.com/stretchr/testify/suite"
+)
+
+type BreakerTest struct {
+	suite.Suite
+	stub    *stubSender
+	breaker *crm.Breaker
+	now     time.Time
+}
+
+func TestBreakerController(t *testing.T) {
+	suite.Run(t, new(BreakerTest))
+}
+
+func (bt *BreakerTest) SetupTest() {
+	bt.stub = &stubSender{status: http.StatusServiceUnavailable}
+	bt.now = time.Date(2019, 3, 25, 10, 0, 0, 0, time.UTC)
+	bt.breaker = crm.NewBreaker(bt.stub, "http://crm.test", 3, time.Minute)
+	bt.breaker.Now = func() time.Time { return bt.now }
+}
+
+func (bt *BreakerTest) send() error {
+	_, err := bt.breaker.Send(context.Background(), crm.Request{ID: 1})
+	return err
+}
+
+func (bt *BreakerTest) status(code int) {
+	atomic.StoreInt32(&bt.stub.status, int32(code))
+}
+
+func (bt *BreakerTest) TestOpens() {
+	bt.Nil(bt.send())
+	bt.Nil(bt.send())
+	bt.Equal(crm.Closed, bt.breaker.State())
+	bt.Nil(bt.send())
+	bt.Equal(crm.Open, bt.breaker.State())
+	bt.Equal(time.Minute, bt.breaker.Ready())
+
+	bt.Equal(crm.ErrCircuitOpen, bt.send())
+}
+
+func (bt *BreakerTest) TestSuccessResetsFailures() {
+	bt.Nil(bt.send())
+	bt.Nil(bt.send())
+	bt.status(http.StatusUnprocessableEntity)
+	bt.Nil(bt.send())
+	bt.status(http.StatusBadGateway)
+	bt.Nil(bt.send())
+	bt.Nil(bt.send())
+	bt.Equal(crm.Closed, bt.breaker.State())
+}
+
+func (bt *BreakerTest) TestHalfOpen() {
+	for i := 0; i < 3; i++ {
+		bt.send()
+	}
+	bt.now = bt.now.Add(30 * time.Second)
+	bt.Equal(30*time.Second, bt.breaker.Ready())
+
+	// The probe fails: open again for another cooldown.
+	bt.now = bt.now.Add(30 * time.Second)
+	bt.Equal(time.Duration(0), bt.breaker.Ready())
+	bt.Nil(bt.send())
+	bt.Equal(crm.Open, bt.breaker.State())
+	bt.Equal(crm.ErrCircuitOpen, bt.send())
+
+	// The probe succeeds.
+	bt.now = bt.now.Add(time.Minute)
+	bt.status(http.StatusCreated)
+	bt.Nil(bt.send())
+	bt.Equal(crm.Closed, bt.breaker.State())
+	bt.Nil(bt.send())
+}
+
+func (bt *BreakerTest) TestOneProbe() {
+	for i := 0; i < 3; i++ {
+		bt.send()
+	}
+	bt.now = bt.now.Add(time.Minute)
+	bt.stub.delay = 50 * time.Millisecond
+	bt.status(http.StatusCreated)
+
+	done := make(chan error)
+	go func() { done <- bt.send() }()
+	time.Sleep(10 * time.Millisecond)
+	bt.Equal(crm.HalfOpen, bt.breaker.State())
+	bt.True(bt.breaker.Ready() > 0)
+	bt.Equal(crm.ErrCircuitOpen, bt.send())
+	bt.Nil(<-done)
+	bt.Equal(crm.Closed, bt.breaker.State())
+}
+
+func (bt *BreakerTest) TestMetrics() {
+	for i := 0; i < 4; i++ {
+		bt.send()
+	}
+	m := expvar.Get("crm_breaker").(*expvar.Map).Get("http://crm.test").(*expvar.Map)
+	bt.Equal(`"open"`, m.Get("state").String())
+	bt.Equal("1", m.Get("opened").String())
+	bt.Equal("1", m.Get("rejected").String())
+}
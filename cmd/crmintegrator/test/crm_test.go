@@ -0,0 +1,157 @@
+package test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/crm"
+	"github.com/josesolana/csv-reader/config"
+	c "github.com/josesolana/csv-reader/constants"
+	"github.com/stretchr/testify/suite"
+)
+
+type CRMTest struct {
+	suite.Suite
+	server *httptest.Server
+	// last Request received by the server.
+	last *http.Request
+	body []byte
+	cfg  *config.Config
+}
+
+func TestCRMController(t *testing.T) {
+	suite.Run(t, new(CRMTest))
+}
+
+func (ct *CRMTest) SetupTest() {
+	ct.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ct.last = r
+		ct.body, _ = ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 1}`))
+	}))
+	ct.cfg = config.Default()
+	ct.cfg.CRMUrl = ct.server.URL + "/"
+}
+
+func (ct *CRMTest) TearDownTest() {
+	ct.server.Close()
+}
+
+func (ct *CRMTest) TestSend() {
+	ct.cfg.CRMPath = "customers/{{.ID}}"
+	ct.cfg.CRMMethod = "put"
+	ct.cfg.CRMHeaders = "X-Tenant: acme; X-Source: csv"
+	cl, err := crm.NewClient(ct.cfg)
+	ct.Nil(err)
+
+	resp, err := cl.Send(context.Background(), crm.Request{ID: 42, Body: []byte(`{"name": "Fons"}`)})
+	ct.Nil(err)
+	ct.Equal(http.StatusCreated, resp.Status)
+	ct.Equal(`{"id": 1}`, string(resp.Body))
+
+	ct.Equal(http.MethodPut, ct.last.Method)
+	ct.Equal("/customers/42", ct.last.URL.Path)
+	ct.Equal("acme", ct.last.Header.Get("X-Tenant"))
+	ct.Equal("csv", ct.last.Header.Get("X-Source"))
+	ct.Equal("application/json", ct.last.Header.Get("Content-Type"))
+	ct.Equal(`{"name": "Fons"}`, string(ct.body))
+}
+
+func (ct *CRMTest) TestTimeout() {
+	ct.cfg.CRMPath = "/slow"
+	ct.cfg.TimeOut = 10 * time.Millisecond
+	cl, err := crm.NewClient(ct.cfg)
+	ct.Nil(err)
+
+	_, err = cl.Send(context.Background(), crm.Request{ID: 1})
+	ct.Error(err)
+}
+
+func (ct *CRMTest) TestInvalidSettings() {
+	ct.cfg.CRMPath = "{{.ID"
+	_, err := crm.NewClient(ct.cfg)
+	ct.Error(err)
+
+	ct.cfg.CRMPath = ""
+	ct.cfg.CRMHeaders = "X-Tenant"
+	_, err = crm.NewClient(ct.cfg)
+	ct.EqualError(err, c.ErrCRMHeader)
+}
+
+func (ct *CRMTest) TestChaos() {
+	cl, err := crm.NewClient(ct.cfg)
+	ct.Nil(err)
+	n := 0
+	chaos := &crm.Chaos{Next: cl, FailRate: 50, Rand: func() int { n++; return []int{10, 11, 60}[n-1] }}
+
+	_, err = chaos.Send(context.Background(), crm.Request{ID: 1})
+	ct.EqualError(err, c.ErrCRMChaos)
+
+	resp, err := chaos.Send(context.Background(), crm.Request{ID: 1})
+	ct.Nil(err)
+	ct.Equal(http.StatusServiceUnavailable, resp.Status)
+
+	resp, err = chaos.Send(context.Background(), crm.Request{ID: 1})
+	ct.Nil(err)
+	ct.Equal(http.StatusCreated, resp.Status)
+}
+
+func (ct *CRMTest) TestNoChaosByDefault() {
+	sender, err := crm.NewSender(ct.cfg)
+	ct.Nil(err)
+	_, ok := sender.(*crm.Client)
+	ct.True(ok)
+}
+
+func (ct *CRMTest) TestClassify() {
+	cases := map[int]crm.Outcome{
+		http.StatusOK:                  crm.Success,
+		http.StatusCreated:             crm.Success,
+		http.StatusNoContent:           crm.Success,
+		http.StatusRequestTimeout:      crm.Retryable,
+		http.StatusTooEarly:            crm.Retryable,
+		http.StatusTooManyRequests:     crm.Retryable,
+		http.StatusInternalServerError: crm.Retryable,
+		http.StatusServiceUnavailable:  crm.Retryable,
+		http.StatusBadRequest:          crm.Permanent,
+		http.StatusUnauthorized:        crm.Permanent,
+		http.StatusNotFound:            crm.Permanent,
+		http.StatusUnprocessableEntity: crm.Permanent,
+		http.StatusNotModified:         crm.Permanent,
+	}
+	for status, outcome := range cases {
+		ct.Equal(outcome, crm.Classify(&crm.Response{Status: status}, nil), status)
+	}
+	ct.Equal(crm.Retryable, crm.Classify(nil, context.DeadlineExceeded))
+}
+
+func (ct *CRMTest) TestRetryAfter() {
+	now := time.Date(2019, 3, 25, 10, 0, 0, 0, time.UTC)
+	resp := &crm.Response{Status: http.StatusTooManyRequests, Header: http.Header{}}
+
+	_, ok := crm.RetryAfter(resp, now)
+	ct.False(ok)
+
+	resp.Header.Set("Retry-After", "120")
+	d, ok := crm.RetryAfter(resp, now)
+	ct.True(ok)
+	ct.Equal(2*time.Minute, d)
+
+	resp.Status = http.StatusServiceUnavailable
+	resp.Header.Set("Retry-After", now.Add(30*time.Second).Format(http.TimeFormat))
+	d, ok = crm.RetryAfter(resp, now)
+	ct.True(ok)
+	ct.Equal(30*time.Second, d)
+
+	resp.Status = http.StatusInternalServerError
+	_, ok = crm.RetryAfter(resp, now)
+	ct.False(ok)
+}
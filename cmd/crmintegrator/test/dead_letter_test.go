@@ -0,0 +1,122 @@
+package test
+
+import (
+	"fmt"
+	"log"
+	"testing"
+
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/database"
+	"github.com/josesolana/csv-reader/config"
+	c "github.com/josesolana/csv-reader/constants"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+)
+
+const deadLetterTable = "dead_letter_mock"
+
+type DeadLetterTest struct {
+	IntegratorTest
+	cfg *config.Config
+}
+
+func TestDeadLetterController(t *testing.T) {
+	suite.Run(t, new(DeadLetterTest))
+}
+
+func (dt *DeadLetterTest) SetupTest() {
+	dt.cfg = config.Default()
+	query := `CREATE TABLE %s (
+			id SERIAL PRIMARY KEY,
+			is_processed boolean DEFAULT FALSE,
+			retry int DEFAULT 0,
+			%s_name TEXT NOT NULL
+			)`
+	dt.exec(fmt.Sprintf(query, deadLetterTable, deadLetterTable))
+	dt.exec(fmt.Sprintf("INSERT INTO %s (retry, %s_name) VALUES (0, 'Fons'), (%d, 'Mal')",
+		deadLetterTable, deadLetterTable, c.TotalRetry+1))
+}
+
+func (dt *DeadLetterTest) TearDownTest() {
+	dt.exec("DROP TABLE IF EXISTS " + deadLetterTable)
+	dt.exec("DELETE FROM "+c.DeadLetterTable+" WHERE table_name = $1", deadLetterTable)
+}
+
+func (dt *DeadLetterTest) exec(query string, args ...interface{}) {
+	if _, err := dt.db.Exec(query, args...); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// claim Lease the pending rows, as the integrator does before sending them.
+func (dt *DeadLetterTest) claim(db database.DB) {
+	rows, err := db.Claim()
+	dt.Nil(err)
+	rows.Close()
+}
+
+func (dt *DeadLetterTest) TestLifecycle() {
+	// Row 2 has exhausted its retries before dead letters existed.
+	db, err := database.NewDB(deadLetterTable, dt.cfg)
+	dt.Require().Nil(err)
+	defer db.Close()
+	dl := database.NewDeadLetters(dt.cfg)
+	defer dl.Close()
+
+	letters, err := dl.List(deadLetterTable)
+	dt.Nil(err)
+	dt.Len(letters, 1)
+	dt.Equal(2, letters[0].ID)
+
+	// Row 1 is rejected by the CRM.
+	dt.claim(db)
+	_, err = db.SetIdempotencyKey(1, "dead_letter_mock:1")
+	dt.Nil(err)
+	err = db.SetAsDeadLetter(1, database.Failure{Status: 422, Error: "422 Unprocessable Entity", Response: `{"error": "email"}`})
+	dt.Nil(err)
+	letter, row, err := dl.Show(deadLetterTable, 1)
+	dt.Nil(err)
+	dt.Equal(422, letter.Status)
+	dt.Equal(`{"error": "email"}`, letter.Response)
+	dt.Equal("Fons", row[deadLetterTable+"_name"])
+
+	n, err := dl.Requeue(deadLetterTable, []int{1})
+	dt.Nil(err)
+	dt.Equal(int64(1), n)
+	letters, err = dl.List(deadLetterTable)
+	dt.Nil(err)
+	dt.Len(letters, 1)
+
+	// Sent as a new request.
+	var key, lastError *string
+	var lastStatus *int
+	query := fmt.Sprintf("SELECT idempotency_key, last_status, last_error FROM %s WHERE id = 1", deadLetterTable)
+	dt.Nil(dt.db.QueryRow(query).Scan(&key, &lastStatus, &lastError))
+	dt.Nil(key)
+	dt.Nil(lastStatus)
+	dt.Nil(lastError)
+
+	n, err = dl.Purge(deadLetterTable, nil)
+	dt.Nil(err)
+	dt.Equal(int64(1), n)
+	letters, err = dl.List(deadLetterTable)
+	dt.Nil(err)
+	dt.Len(letters, 0)
+}
+
+func (dt *DeadLetterTest) TestRetriesExhausted() {
+	db, err := database.NewDB(deadLetterTable, dt.cfg)
+	dt.Require().Nil(err)
+	defer db.Close()
+	dl := database.NewDeadLetters(dt.cfg)
+	defer dl.Close()
+
+	for i := 0; i <= c.TotalRetry; i++ {
+		dt.claim(db)
+		dt.Nil(db.IncreaseRetry(1, 0, database.Failure{Status: 503, Error: "503 Service Unavailable"}))
+	}
+	letters, err := dl.List(deadLetterTable)
+	dt.Nil(err)
+	dt.Len(letters, 2)
+	dt.Equal(503, letters[1].Status)
+	dt.Equal(c.TotalRetry+1, letters[1].Retry)
+}
@@ -0,0 +1,268 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/crm"
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/database"
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/integrator"
+	"github.com/josesolana/csv-reader/config"
+	c "github.com/josesolana/csv-reader/constants"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+)
+
+const idempotencyTable = "idempotency_mock"
+
+// fakeCRM Creates a customer for every request, but the ones whose
+// Idempotency-Key has been seen: their first response is sent again.
+// If lose is set, the first response of every key is lost: the customer
+// is created but the answer comes after the client timeout.
+type fakeCRM struct {
+	*httptest.Server
+	mu       sync.Mutex
+	lose     bool
+	seen     map[string][]byte
+	created  int
+	requests int
+}
+
+func newFakeCRM(lose bool) *fakeCRM {
+	f := &fakeCRM{lose: lose, seen: make(map[string][]byte)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	return f
+}
+
+func (f *fakeCRM) handle(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get(c.IdempotencyHeader)
+	f.mu.Lock()
+	f.requests++
+	body, ok := f.seen[key]
+	if !ok || key == "" {
+		f.created++
+		body = []byte(fmt.Sprintf(`{"id": %d}`, f.created))
+		f.seen[key] = body
+	}
+	f.mu.Unlock()
+
+	if !ok && f.lose {
+		time.Sleep(200 * time.Millisecond)
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(body)
+}
+
+func (f *fakeCRM) counts() (created, requests int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.created, f.requests
+}
+
+type IdempotencyTest struct {
+	suite.Suite
+	fake *fakeCRM
+	cfg  *config.Config
+}
+
+func TestIdempotencyController(t *testing.T) {
+	suite.Run(t, new(IdempotencyTest))
+}
+
+func (it *IdempotencyTest) SetupTest() {
+	it.fake = newFakeCRM(true)
+	it.cfg = config.Default()
+	it.cfg.CRMUrl = it.fake.URL
+	it.cfg.TimeOut = 50 * time.Millisecond
+}
+
+func (it *IdempotencyTest) TearDownTest() {
+	it.fake.Close()
+}
+
+func (it *IdempotencyTest) TestKey() {
+	key := crm.IdempotencyKey
+	byID := key(c.IdempotencyByID, "customers", 7, []byte(`{"name": "Fons"}`))
+	it.Regexp(`^customers:7:[0-9a-f]{8}$`, byID)
+	it.Equal(byID, key(c.IdempotencyByID, "customers", 7, []byte(`{"name": "Fons"}`)))
+	it.NotEqual(byID, key(c.IdempotencyByID, "customers", 7, []byte(`{"name": "Mal"}`)))
+	it.NotEqual(byID, key(c.IdempotencyByID, "customers", 8, []byte(`{"name": "Fons"}`)))
+
+	hash := key(c.IdempotencyByHash, "customers", 7, []byte(`{"name": "Fons"}`))
+	it.Len(hash, 64)
+	it.Equal(hash, key(c.IdempotencyByHash, "customers", 8, []byte(`{"name": "Fons"}`)))
+	it.NotEqual(hash, key(c.IdempotencyByHash, "customers", 7, []byte(`{"name": "Mal"}`)))
+	it.NotEqual(hash, key(c.IdempotencyByHash, "leads", 7, []byte(`{"name": "Fons"}`)))
+}
+
+func (it *IdempotencyTest) TestLostResponse() {
+	cl, err := crm.NewClient(it.cfg)
+	it.Nil(err)
+	r := crm.Request{ID: 1, Body: []byte(`{"name": "Fons"}`), IdempotencyKey: "customers:1"}
+
+	// The customer is created, but the client doesn't know it.
+	resp, err := cl.Send(context.Background(), r)
+	it.Error(err)
+	it.Equal(crm.Retryable, crm.Classify(resp, err))
+
+	resp, err = cl.Send(context.Background(), r)
+	it.Nil(err)
+	it.Equal(`{"id": 1}`, string(resp.Body))
+
+	// A new client, as after a restart, with the key saved into the row.
+	cl, err = crm.NewClient(it.cfg)
+	it.Nil(err)
+	_, err = cl.Send(context.Background(), r)
+	it.Nil(err)
+
+	created, requests := it.fake.counts()
+	it.Equal(1, created)
+	it.Equal(3, requests)
+}
+
+func (it *IdempotencyTest) TestNoHeader() {
+	it.cfg.IdempotencyHeader = ""
+	it.cfg.TimeOut = time.Second
+	cl, err := crm.NewClient(it.cfg)
+	it.Nil(err)
+	r := crm.Request{ID: 1, IdempotencyKey: "customers:1"}
+	for i := 0; i < 2; i++ {
+		_, err = cl.Send(context.Background(), r)
+		it.Nil(err)
+	}
+	created, _ := it.fake.counts()
+	it.Equal(2, created)
+}
+
+// IdempotencyDBTest Rows migrated against a CRM which loses responses.
+type IdempotencyDBTest struct {
+	IntegratorTest
+	fake *fakeCRM
+	cfg  *config.Config
+}
+
+func TestIdempotencyDBController(t *testing.T) {
+	suite.Run(t, new(IdempotencyDBTest))
+}
+
+func (it *IdempotencyDBTest) SetupTest() {
+	it.fake = newFakeCRM(true)
+	it.cfg = config.Default()
+	it.cfg.Workers = 2
+	it.cfg.Buff = 2
+	it.cfg.CRMUrl = it.fake.URL
+	it.cfg.TimeOut = 50 * time.Millisecond
+	it.cfg.RetryMin = time.Millisecond
+	it.cfg.RetryMax = time.Millisecond
+	it.cfg.RetryJitter = false
+
+	query := `CREATE TABLE %s (
+			id SERIAL PRIMARY KEY,
+			is_processed boolean DEFAULT FALSE,
+			retry int DEFAULT 0,
+			name TEXT NOT NULL
+			)`
+	it.exec(fmt.Sprintf(query, idempotencyTable))
+	it.exec(fmt.Sprintf("INSERT INTO %s (name) VALUES ('Fons'), ('Mal')", idempotencyTable))
+}
+
+func (it *IdempotencyDBTest) TearDownTest() {
+	it.fake.Close()
+	it.exec("DROP TABLE IF EXISTS " + idempotencyTable)
+}
+
+func (it *IdempotencyDBTest) exec(query string, args ...interface{}) {
+	if _, err := it.db.Exec(query, args...); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func (it *IdempotencyDBTest) pending() int {
+	var n int
+	query := "SELECT count(*) FROM " + idempotencyTable + " WHERE NOT is_processed"
+	if err := it.db.QueryRow(query).Scan(&n); err != nil {
+		log.Fatalln(err)
+	}
+	return n
+}
+
+// migrate Run the integrator until every row has been processed.
+func (it *IdempotencyDBTest) migrate() {
+	sender, err := crm.NewSender(it.cfg)
+	it.Nil(err)
+	sig := make(chan os.Signal, 1)
+	i := integrator.NewIntegrator(idempotencyTable, sig, it.cfg, sender)
+	done := make(chan struct{})
+	go func() {
+		i.Migrate()
+		close(done)
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for it.pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sig <- os.Interrupt
+	<-done
+	it.Equal(0, it.pending())
+}
+
+func (it *IdempotencyDBTest) TestRetriesAndRestarts() {
+	it.migrate()
+	created, requests := it.fake.counts()
+	it.Equal(2, created)
+	it.Equal(4, requests)
+
+	// Rows are sent again after a restart, e.g. if they were not set as
+	// processed before a crash.
+	it.exec("UPDATE " + idempotencyTable + " SET is_processed = FALSE")
+	it.migrate()
+	created, requests = it.fake.counts()
+	it.Equal(2, created)
+	it.Equal(6, requests)
+}
+
+func (it *IdempotencyDBTest) TestKeyIsSavedOnce() {
+	db, err := database.NewDB(idempotencyTable, it.cfg)
+	it.Require().Nil(err)
+	key, err := db.SetIdempotencyKey(1, "first")
+	it.Nil(err)
+	it.Equal("first", key)
+	db.Close()
+
+	db, err = database.NewDB(idempotencyTable, it.cfg)
+	it.Require().Nil(err)
+	defer db.Close()
+	key, err = db.SetIdempotencyKey(1, "second")
+	it.Nil(err)
+	it.Equal("first", key)
+}
+
+// TestBatch Rows are sent at once, though they are sent by different
+// workers one by one.
+func (it *IdempotencyDBTest) TestBatch() {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`[{}, {}]`))
+	}))
+	defer server.Close()
+	it.cfg.CRMUrl = server.URL
+	it.cfg.CRMBatchSize = 2
+
+	it.migrate()
+	it.Equal([]string{`[{"name":"Fons"},{"name":"Mal"}]`}, bodies)
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/josesolana/csv-reader/cmd/crmintegrator/database"
+	"github.com/josesolana/csv-reader/config"
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/suite"
 )
@@ -20,7 +21,7 @@ func TestIntegratorController(t *testing.T) {
 }
 
 func (it *IntegratorTest) SetupSuite() {
-	it.db = database.ConnectDb()
+	it.db = database.ConnectDb(config.Default().Database)
 }
 
 func (it *IntegratorTest) TearDownSuite() {
@@ -0,0 +1,145 @@
+package test
+
+import (
+	"fmt"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/database"
+	"github.com/josesolana/csv-reader/config"
+	c "github.com/josesolana/csv-reader/constants"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+)
+
+const leaseTable = "lease_mock"
+
+type LeaseTest struct {
+	IntegratorTest
+	cfg *config.Config
+}
+
+func TestLeaseController(t *testing.T) {
+	suite.Run(t, new(LeaseTest))
+}
+
+func (lt *LeaseTest) SetupTest() {
+	lt.cfg = config.Default()
+	query := `CREATE TABLE %s (
+			id SERIAL PRIMARY KEY,
+			is_processed boolean DEFAULT FALSE,
+			retry int DEFAULT 0,
+			name TEXT NOT NULL
+			)`
+	lt.exec(fmt.Sprintf(query, leaseTable))
+	lt.exec(fmt.Sprintf("INSERT INTO %s (name) VALUES ('Fons'), ('Mal')", leaseTable))
+}
+
+func (lt *LeaseTest) TearDownTest() {
+	lt.exec("DROP TABLE IF EXISTS " + leaseTable)
+}
+
+func (lt *LeaseTest) exec(query string, args ...interface{}) {
+	if _, err := lt.db.Exec(query, args...); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// claim Ids of the rows leased by db.
+func (lt *LeaseTest) claim(db database.DB) []int {
+	rows, err := db.Claim()
+	lt.Nil(err)
+	defer rows.Close()
+	cols, err := rows.Columns()
+	lt.Nil(err)
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		vals := make([]interface{}, len(cols))
+		for i := range vals {
+			vals[i] = new(interface{})
+		}
+		vals[c.IDPos] = &id
+		lt.Nil(rows.Scan(vals...))
+		ids = append(ids, id)
+	}
+	lt.Nil(rows.Err())
+	return ids
+}
+
+func (lt *LeaseTest) claimedBy(id int) (by *string) {
+	query := fmt.Sprintf("SELECT claimed_by FROM %s WHERE id = $1", leaseTable)
+	if err := lt.db.QueryRow(query, id).Scan(&by); err != nil {
+		log.Fatalln(err)
+	}
+	return by
+}
+
+func (lt *LeaseTest) TestTwoInstances() {
+	a, err := database.NewDB(leaseTable, lt.cfg)
+	lt.Require().Nil(err)
+	defer a.Close()
+	b, err := database.NewDB(leaseTable, lt.cfg)
+	lt.Require().Nil(err)
+
+	lt.ElementsMatch([]int{1, 2}, lt.claim(a))
+	lt.Empty(lt.claim(b))
+
+	// Row 1 is finalized by a. Row 2's lease expires, so b takes it.
+	lt.Nil(a.SetAsProcessed(1))
+	lt.Nil(lt.claimedBy(1))
+	lt.exec(fmt.Sprintf("UPDATE %s SET claimed_until = NOW() - INTERVAL '1 second' WHERE id = 2", leaseTable))
+	lt.Equal([]int{2}, lt.claim(b))
+
+	// a has lost row 2, so its update is skipped.
+	lt.Nil(a.IncreaseRetry(2, time.Minute, database.Failure{Error: "timeout"}))
+	var retry int
+	lt.Nil(lt.db.QueryRow(fmt.Sprintf("SELECT retry FROM %s WHERE id = 2", leaseTable)).Scan(&retry))
+	lt.Equal(0, retry)
+
+	// b stops before sending row 2, which is released.
+	lt.NotNil(lt.claimedBy(2))
+	b.Close()
+	lt.Nil(lt.claimedBy(2))
+	lt.Equal([]int{2}, lt.claim(a))
+}
+
+// TestTables A missing table is an error. An empty one is not.
+func (lt *LeaseTest) TestTables() {
+	_, err := database.NewDB("lease_mock_missing", lt.cfg)
+	lt.EqualError(err, c.ErrTableNoExists)
+
+	lt.exec("DELETE FROM " + leaseTable)
+	db, err := database.NewDB(leaseTable, lt.cfg)
+	lt.Require().Nil(err)
+	defer db.Close()
+	lt.Empty(lt.claim(db))
+}
+
+// TestStateColumns Without the columns saved by csvreader, only data
+// columns are read, named after themselves.
+func (lt *LeaseTest) TestStateColumns() {
+	db, err := database.NewDB(leaseTable, lt.cfg)
+	lt.Nil(err)
+	defer db.Close()
+	lt.Equal([]string{"name"}, db.Columns())
+
+	rows, err := db.Claim()
+	lt.Nil(err)
+	defer rows.Close()
+	cols, err := rows.Columns()
+	lt.Nil(err)
+	lt.Equal([]string{"id", "is_processed", "retry", "idempotency_key", "name"}, cols)
+}
+
+// TestClaimOrder Claimed rows are read by id, whatever their next attempt.
+func (lt *LeaseTest) TestClaimOrder() {
+	db, err := database.NewDB(leaseTable, lt.cfg)
+	lt.Require().Nil(err)
+	defer db.Close()
+	lt.exec(fmt.Sprintf("UPDATE %s SET next_attempt_at = NOW() - id * INTERVAL '1 minute'", leaseTable))
+
+	lt.Equal([]int{1, 2}, lt.claim(db))
+}
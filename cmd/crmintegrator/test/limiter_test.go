@@ -0,0 +1,147 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/crm"
+	"github.com/josesolana/csv-reader/config"
+	"github.com/stretchr/testify/suite"
+)
+
+// stubSender Answers status after delay, counting requests in flight.
+type stubSender struct {
+	status   int32
+	delay    time.Duration
+	inFlight int32
+	maxSeen  int32
+}
+
+func (s *stubSender) Send(ctx context.Context, r crm.Request) (*crm.Response, error) {
+	n := atomic.AddInt32(&s.inFlight, 1)
+	defer atomic.AddInt32(&s.inFlight, -1)
+	for {
+		max := atomic.LoadInt32(&s.maxSeen)
+		if n <= max || atomic.CompareAndSwapInt32(&s.maxSeen, max, n) {
+			break
+		}
+	}
+	time.Sleep(s.delay)
+	return &crm.Response{Status: int(atomic.LoadInt32(&s.status)), Header: http.Header{}}, nil
+}
+
+type LimiterTest struct {
+	suite.Suite
+	stub *stubSender
+}
+
+func TestLimiterController(t *testing.T) {
+	suite.Run(t, new(LimiterTest))
+}
+
+func (lt *LimiterTest) SetupTest() {
+	lt.stub = &stubSender{status: http.StatusCreated}
+}
+
+func (lt *LimiterTest) TestRate() {
+	l := crm.NewLimiter(lt.stub, 100, 1, 0)
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		_, err := l.Send(context.Background(), crm.Request{ID: i})
+		lt.Nil(err)
+	}
+	lt.True(time.Since(start) >= 90*time.Millisecond, time.Since(start))
+}
+
+func (lt *LimiterTest) TestBurst() {
+	l := crm.NewLimiter(lt.stub, 1, 5, 0)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, err := l.Send(context.Background(), crm.Request{ID: i})
+		lt.Nil(err)
+	}
+	lt.True(time.Since(start) < 500*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := l.Send(ctx, crm.Request{ID: 6})
+	lt.Error(err)
+}
+
+func (lt *LimiterTest) TestMaxInFlight() {
+	lt.stub.delay = 20 * time.Millisecond
+	l := crm.NewLimiter(lt.stub, 0, 1, 3)
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			l.Send(context.Background(), crm.Request{ID: id})
+		}(i)
+	}
+	wg.Wait()
+	lt.Equal(int32(3), lt.stub.maxSeen)
+}
+
+func (lt *LimiterTest) TestAIMD() {
+	l := crm.NewLimiter(lt.stub, 1000, 1000, 0)
+	lt.Equal(1000.0, l.Rate())
+
+	atomic.StoreInt32(&lt.stub.status, http.StatusTooManyRequests)
+	l.Send(context.Background(), crm.Request{ID: 1})
+	lt.Equal(500.0, l.Rate())
+	l.Send(context.Background(), crm.Request{ID: 2})
+	lt.Equal(250.0, l.Rate())
+	for l.Rate() > l.Min {
+		l.Send(context.Background(), crm.Request{ID: 3})
+	}
+	l.Send(context.Background(), crm.Request{ID: 3})
+	lt.Equal(10.0, l.Rate())
+
+	atomic.StoreInt32(&lt.stub.status, http.StatusOK)
+	l.Send(context.Background(), crm.Request{ID: 4})
+	lt.Equal(60.0, l.Rate())
+
+	l.Increase = 2000
+	l.Send(context.Background(), crm.Request{ID: 5})
+	lt.Equal(1000.0, l.Rate())
+}
+
+// TestDecreaseOnce Workers throttled at once halve the rate only once.
+func (lt *LimiterTest) TestDecreaseOnce() {
+	lt.stub.delay = 50 * time.Millisecond
+	atomic.StoreInt32(&lt.stub.status, http.StatusTooManyRequests)
+	l := crm.NewLimiter(lt.stub, 1000, 1000, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			l.Send(context.Background(), crm.Request{ID: id})
+		}(i)
+	}
+	wg.Wait()
+	lt.Equal(500.0, l.Rate())
+
+	l.Send(context.Background(), crm.Request{ID: 10})
+	lt.Equal(250.0, l.Rate())
+}
+
+func (lt *LimiterTest) TestSender() {
+	cfg := config.Default()
+	sender, err := crm.NewSender(cfg)
+	lt.Nil(err)
+	_, ok := sender.(*crm.Client)
+	lt.True(ok)
+
+	cfg.CRMRate = 50
+	sender, err = crm.NewSender(cfg)
+	lt.Nil(err)
+	_, ok = sender.(*crm.Limiter)
+	lt.True(ok)
+}
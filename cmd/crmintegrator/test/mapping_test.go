@@ -0,0 +1,89 @@
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/crm"
+	c "github.com/josesolana/csv-reader/constants"
+	"github.com/stretchr/testify/suite"
+)
+
+type MappingTest struct {
+	suite.Suite
+	columns []string
+}
+
+func TestMappingController(t *testing.T) {
+	suite.Run(t, new(MappingTest))
+}
+
+func (mt *MappingTest) SetupTest() {
+	mt.columns = []string{"First Name", "last_name", "Email", "age", "vip", "signup", "city", "tags"}
+}
+
+func (mt *MappingTest) render(spec string, row map[string]string) string {
+	m, err := crm.ParseMapping([]byte(spec), mt.columns)
+	mt.Require().Nil(err)
+	obj, err := m.Render(row)
+	mt.Require().Nil(err)
+	body, err := json.Marshal(obj)
+	mt.Require().Nil(err)
+	return string(body)
+}
+
+func (mt *MappingTest) TestRender() {
+	spec := `{
+		"name": "{{concat (index . \"First Name\") \" \" .last_name | trim}}",
+		"email": "{{.Email | trim | lower}}",
+		"age": {"template": "{{.age}}", "type": "int"},
+		"vip": {"template": "{{.vip}}", "type": "bool"},
+		"signed_up": "{{date \"02/01/2006\" \"2006-01-02\" .signup}}",
+		"address.city": "{{.city | upper}}",
+		"address.country": "AR",
+		"tags": {"template": "{{.tags}}", "type": "json"},
+		"source": "csv",
+		"version": 2,
+		"deleted": false
+	}`
+	row := map[string]string{
+		"First Name": "Fons", "last_name": "Solana ", "Email": " Fons@Example.com",
+		"age": "42", "vip": "true", "signup": "25/03/2019", "city": "Córdoba", "tags": `["a", "b"]`,
+	}
+	mt.Equal(`{"address":{"city":"CÓRDOBA","country":"AR"},"age":42,"deleted":false,`+
+		`"email":"fons@example.com","name":"Fons Solana","signed_up":"2019-03-25","source":"csv",`+
+		`"tags":["a","b"],"version":2,"vip":true}`, mt.render(spec, row))
+}
+
+func (mt *MappingTest) TestNull() {
+	spec := `{"age": {"template": "{{.age}}", "type": "int"}, "city": "{{.city | default \"unknown\"}}", "signup": "{{date \"02/01/2006\" \"2006-01-02\" .signup}}"}`
+	mt.Equal(`{"age":null,"city":"unknown","signup":""}`, mt.render(spec, map[string]string{"age": "", "city": "", "signup": ""}))
+}
+
+func (mt *MappingTest) TestInvalid() {
+	specs := []string{
+		`{"name": "{{.nickname}}"}`,
+		`{"name": "{{.Email"}`,
+		`{"age": {"template": "{{.age}}", "type": "decimal"}}`,
+		`{"address": "AR", "address.city": "{{.city}}"}`,
+		`{"address..city": "{{.city}}"}`,
+		`["name"]`,
+	}
+	for _, spec := range specs {
+		_, err := crm.ParseMapping([]byte(spec), mt.columns)
+		mt.Error(err, spec)
+	}
+
+	_, err := crm.ParseMapping([]byte(`{"name": "{{.Email}}"}`), nil)
+	mt.EqualError(err, c.ErrMappingColumns)
+}
+
+func (mt *MappingTest) TestRowErrors() {
+	m, err := crm.ParseMapping([]byte(`{"age": {"template": "{{.age}}", "type": "int"}, "signup": "{{date \"02/01/2006\" \"2006-01-02\" .signup}}"}`), mt.columns)
+	mt.Nil(err)
+	_, err = m.Render(map[string]string{"age": "forty"})
+	mt.True(strings.HasPrefix(err.Error(), c.ErrMapping), err)
+	_, err = m.Render(map[string]string{"age": "40", "signup": "2019-03-25"})
+	mt.Error(err)
+}
@@ -0,0 +1,81 @@
+package test
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/crm"
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/integrator"
+	"github.com/josesolana/csv-reader/config"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+)
+
+const notifyTable = "notify_mock"
+
+type NotifyTest struct {
+	IntegratorTest
+	fake *fakeCRM
+	cfg  *config.Config
+}
+
+func TestNotifyController(t *testing.T) {
+	suite.Run(t, new(NotifyTest))
+}
+
+func (nt *NotifyTest) SetupTest() {
+	nt.fake = newFakeCRM(false)
+	nt.cfg = config.Default()
+	nt.cfg.Workers = 2
+	nt.cfg.CRMUrl = nt.fake.URL
+
+	query := `CREATE TABLE %s (
+			id SERIAL PRIMARY KEY,
+			is_processed boolean DEFAULT FALSE,
+			retry int DEFAULT 0,
+			name TEXT NOT NULL
+			)`
+	nt.exec(fmt.Sprintf(query, notifyTable))
+}
+
+func (nt *NotifyTest) TearDownTest() {
+	nt.fake.Close()
+	nt.exec("DROP TABLE IF EXISTS " + notifyTable)
+}
+
+func (nt *NotifyTest) exec(query string, args ...interface{}) {
+	if _, err := nt.db.Exec(query, args...); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// TestWakeUp The integrator is sleeping by its backoff, at least 10s,
+// when rows are inserted & notified.
+func (nt *NotifyTest) TestWakeUp() {
+	sender, err := crm.NewSender(nt.cfg)
+	nt.Nil(err)
+	sig := make(chan os.Signal, 1)
+	i := integrator.NewIntegrator(notifyTable, sig, nt.cfg, sender)
+	done := make(chan struct{})
+	go func() {
+		i.Migrate()
+		close(done)
+	}()
+	time.Sleep(500 * time.Millisecond)
+
+	nt.exec(fmt.Sprintf("INSERT INTO %s (name) VALUES ('Fons')", notifyTable))
+	nt.exec("SELECT pg_notify($1, '1')", notifyTable)
+
+	deadline := time.Now().Add(5 * time.Second)
+	created := 0
+	for created == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		created, _ = nt.fake.counts()
+	}
+	sig <- os.Interrupt
+	<-done
+	nt.Equal(1, created)
+}
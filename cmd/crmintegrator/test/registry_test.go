@@ -0,0 +1,154 @@
+package test
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/crm"
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/database"
+	"github.com/josesolana/csv-reader/cmd/crmintegrator/integrator"
+	"github.com/josesolana/csv-reader/config"
+	c "github.com/josesolana/csv-reader/constants"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+)
+
+var registryTables = []string{"registry_mock_a", "registry_mock_b"}
+
+type RegistryTest struct {
+	IntegratorTest
+	fake *fakeCRM
+	cfg  *config.Config
+}
+
+func TestRegistryController(t *testing.T) {
+	suite.Run(t, new(RegistryTest))
+}
+
+func (rt *RegistryTest) SetupTest() {
+	rt.fake = newFakeCRM(false)
+	rt.cfg = config.Default()
+	rt.cfg.Workers = 2
+	rt.cfg.CRMUrl = rt.fake.URL
+
+	// As created by csvreader.
+	query := `CREATE TABLE IF NOT EXISTS %s (
+			table_name VARCHAR(255) PRIMARY KEY,
+			source TEXT NOT NULL,
+			row_count BIGINT NOT NULL DEFAULT 0,
+			status VARCHAR(16) NOT NULL,
+			weight INT NOT NULL DEFAULT 1,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+			)`
+	rt.exec(fmt.Sprintf(query, c.ImportsTable))
+
+	for n, table := range registryTables {
+		query = `CREATE TABLE %s (
+			id SERIAL PRIMARY KEY,
+			is_processed boolean DEFAULT FALSE,
+			retry int DEFAULT 0,
+			name TEXT NOT NULL
+			)`
+		rt.exec(fmt.Sprintf(query, table))
+		rt.exec(fmt.Sprintf("INSERT INTO %s (name) SELECT 'row ' || i FROM generate_series(1, 10) i", table))
+		rt.exec("INSERT INTO "+c.ImportsTable+" (table_name, source, row_count, status, weight) VALUES ($1, $2, 10, $3, $4)",
+			table, table+".csv", c.ImportImported, n+1)
+	}
+}
+
+func (rt *RegistryTest) TearDownTest() {
+	rt.fake.Close()
+	for _, table := range registryTables {
+		rt.exec("DROP TABLE IF EXISTS " + table)
+		rt.exec("DELETE FROM "+c.ImportsTable+" WHERE table_name = $1", table)
+	}
+}
+
+func (rt *RegistryTest) exec(query string, args ...interface{}) {
+	if _, err := rt.db.Exec(query, args...); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func (rt *RegistryTest) status(table string) string {
+	var status string
+	if err := rt.db.QueryRow("SELECT status FROM "+c.ImportsTable+" WHERE table_name = $1", table).Scan(&status); err != nil {
+		log.Fatalln(err)
+	}
+	return status
+}
+
+// TestActive Delivered & failed imports & dropped tables are not active.
+func (rt *RegistryTest) TestActive() {
+	rt.exec("INSERT INTO "+c.ImportsTable+" (table_name, source, status) VALUES ('registry_mock_dropped', '-', $1)", c.ImportImporting)
+	defer rt.exec("DELETE FROM " + c.ImportsTable + " WHERE table_name = 'registry_mock_dropped'")
+
+	conn := database.NewConn(rt.cfg.Database)
+	defer conn.Close()
+	r := database.NewRegistry(conn)
+	imports, err := r.Active()
+	rt.Nil(err)
+	tables := map[string]int{}
+	for _, i := range imports {
+		tables[i.Table] = i.Weight
+	}
+	rt.Equal(1, tables[registryTables[0]])
+	rt.Equal(2, tables[registryTables[1]])
+	rt.NotContains(tables, "registry_mock_dropped")
+
+	ok, err := r.SetDelivered(registryTables[0])
+	rt.Nil(err)
+	rt.True(ok)
+	rt.exec("UPDATE "+c.ImportsTable+" SET status = $1 WHERE table_name = $2", c.ImportFailed, registryTables[1])
+	imports, err = r.Active()
+	rt.Nil(err)
+	for _, i := range imports {
+		rt.NotEqual(registryTables[0], i.Table)
+		rt.NotEqual(registryTables[1], i.Table)
+	}
+}
+
+// TestDaemon Every row of every import is sent by the same workers & the
+// imports are set as delivered. A failed import is left as it is.
+func (rt *RegistryTest) TestDaemon() {
+	query := `CREATE TABLE %s (
+			id SERIAL PRIMARY KEY,
+			is_processed boolean DEFAULT FALSE,
+			retry int DEFAULT 0,
+			name TEXT NOT NULL
+			)`
+	rt.exec(fmt.Sprintf(query, "registry_mock_failed"))
+	rt.exec("INSERT INTO registry_mock_failed (name) VALUES ('Fons')")
+	rt.exec("INSERT INTO "+c.ImportsTable+" (table_name, source, status) VALUES ('registry_mock_failed', '-', $1)", c.ImportFailed)
+	defer rt.exec("DELETE FROM " + c.ImportsTable + " WHERE table_name = 'registry_mock_failed'")
+	defer rt.exec("DROP TABLE registry_mock_failed")
+
+	sender, err := crm.NewSender(rt.cfg)
+	rt.Nil(err)
+	sig := make(chan os.Signal, 1)
+	i := integrator.NewDaemon(sig, rt.cfg, sender)
+	done := make(chan struct{})
+	go func() {
+		i.Migrate()
+		close(done)
+	}()
+
+	deadline := time.Now().Add(c.RegistryPoll + 5*time.Second)
+	for time.Now().Before(deadline) &&
+		(rt.status(registryTables[0]) != c.ImportDelivered || rt.status(registryTables[1]) != c.ImportDelivered) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	sig <- os.Interrupt
+	<-done
+
+	created, _ := rt.fake.counts()
+	rt.Equal(20, created)
+	for _, table := range registryTables {
+		rt.Equal(c.ImportDelivered, rt.status(table))
+	}
+	rt.Equal(c.ImportFailed, rt.status("registry_mock_failed"))
+}
@@ -4,11 +4,14 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"os"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/lib/pq"
+
+	"github.com/josesolana/csv-reader/config"
 	c "github.com/josesolana/csv-reader/constants"
 )
 
@@ -16,37 +19,83 @@ var once sync.Once
 
 // Db Database Handler & Wrapper
 type Db struct {
-	db     *sql.DB
-	insert *sql.Stmt
+	db                             *sql.DB
+	table                          string
+	insert                         *sql.Stmt
+	saveCheckpoint, loadCheckpoint *sql.Stmt
+	// columns, staging & merge are used by InsertBatch.
+	columns                       []string
+	staging, createStaging, merge string
+	// key Columns which identify a row. Every column if it's empty.
+	key        []string
+	onConflict string
+}
+
+// NewDB Set up the environment. Every column is TEXT and identifies a row.
+func NewDB(name string, row []string, cfg *config.Config) DB {
+	return NewDBWithSchema(name, TextSchema(row), nil, c.ConflictSkip, cfg)
 }
 
-// NewDB Set up the environment.
-func NewDB(name string, row []string) DB {
-	if len(row) == 0 {
+// NewDBWithSchema Set up the environment with typed columns.
+// Rows are identified by key, or by every column if it's empty.
+// onConflict is what to do with a row whose key already exists:
+// skip it, overwrite & re-queue it for the CRM, or fail.
+func NewDBWithSchema(name string, schema Schema, key []string, onConflict string, cfg *config.Config) DB {
+	if len(schema) == 0 {
 		return nil
 	}
 
 	db := &Db{
-		db: ConnectDb(),
+		db:         ConnectDb(cfg.Database),
+		onConflict: onConflict,
 	}
 
-	name = path.Base(name)                 // Filename & Extension
-	name = name[:strings.Index(name, ".")] // Without Extension
+	name = TableName(name)
+	if name == "" {
+		log.Fatalf("Cannot create a Table. Error: %s\n", c.ErrTableName)
+	}
 
-	for i, r := range row {
-		row[i] = name + "_" + r
+	original := schema.Names()
+	row := ColumnNames(name, original)
+	for _, k := range key {
+		if i := schema.index(k); i >= 0 {
+			db.key = append(db.key, row[i])
+		}
+	}
+	schema = append(Schema(nil), schema...)
+	for i := range schema {
+		schema[i].Name = row[i]
 	}
 
-	once.Do(func() { createTable(db.db, row, name) })
+	once.Do(func() {
+		createTable(db.db, schema, name, db.key)
+		createCheckpointTable(db.db)
+		createColumnsTable(db.db)
+		createImportsTable(db.db)
+	})
 
+	db.table = name
+	db.saveColumns(name, row, original)
 	db.createInsert(name, row)
+	db.createInsertBatch(name, row)
+	db.createCheckpoint(name)
 	return db
 }
 
+// TableName Table used by a file: its base name without extensions,
+// normalized as an Identifier.
+func TableName(name string) string {
+	name = path.Base(name) // Filename & Extension
+	if i := strings.Index(name, "."); i > 0 {
+		name = name[:i] // Without Extension
+	}
+	return Identifier(name)
+}
+
 // ConnectDb Set Driver, user, pass & database name
-func ConnectDb() *sql.DB {
+func ConnectDb(cfg config.Database) *sql.DB {
 
-	db, err := sql.Open(c.DbDriver, fmt.Sprintf("user=%s dbname=%s password =%s sslmode=disable", c.DbUser, getDBName(), c.DbPass))
+	db, err := sql.Open(c.DbDriver, cfg.DSN())
 	if err != nil {
 		log.Fatalf("Couldn't connect to Database. Error: %s\n", err)
 	}
@@ -58,22 +107,102 @@ func ConnectDb() *sql.DB {
 	return db
 }
 
-// Insert into DB
-func (d *Db) Insert(row ...string) error {
-	interfaceRow := make([]interface{}, len(row))
-	for i, s := range row {
-		interfaceRow[i] = s
+// Insert into DB. A nil value is NULL.
+func (d *Db) Insert(row ...interface{}) error {
+	if _, err := d.insert.Exec(row...); err != nil {
+		return err
+	}
+	return d.notify(d.db, 1)
+}
+
+// InsertBatch Insert rows into DB through COPY into a staging table,
+// which is merged into the table skipping duplicated rows.
+// Every row is inserted or none.
+func (d *Db) InsertBatch(rows [][]interface{}) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := d.copyBatch(tx, rows); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := d.notify(tx, len(rows)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// execer A connection pool or a transaction.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// notify Wake up the integrators listening to the table. A notification
+// made into a transaction is only sent when it's committed.
+func (d *Db) notify(ex execer, rows int) error {
+	_, err := ex.Exec("SELECT pg_notify($1, $2)", d.table, strconv.Itoa(rows))
+	return err
+}
+
+func (d *Db) copyBatch(tx *sql.Tx, rows [][]interface{}) error {
+	if _, err := tx.Exec(d.createStaging); err != nil {
+		return err
+	}
+
+	copyIn, err := tx.Prepare(pq.CopyIn(d.staging, append([]string{c.StagingOrd}, d.columns...)...))
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		if _, err := copyIn.Exec(append([]interface{}{i}, row...)...); err != nil {
+			copyIn.Close()
+			return err
+		}
+	}
+	// Flush buffered rows
+	if _, err := copyIn.Exec(); err != nil {
+		copyIn.Close()
+		return err
+	}
+	if err := copyIn.Close(); err != nil {
+		return err
 	}
-	_, err := d.insert.Exec(interfaceRow...)
+
+	_, err = tx.Exec(d.merge)
+	return err
+}
+
+// SaveCheckpoint Persist the input position up to which every row
+// has been inserted.
+func (d *Db) SaveCheckpoint(offset int64, line int) error {
+	_, err := d.saveCheckpoint.Exec(d.table, offset, line)
 	return err
 }
 
+// LoadCheckpoint Last position saved. sql.ErrNoRows if there is none.
+func (d *Db) LoadCheckpoint() (int64, int, error) {
+	var offset int64
+	var line int
+	err := d.loadCheckpoint.QueryRow(d.table).Scan(&offset, &line)
+	return offset, line, err
+}
+
 //Close returns the connection to the connection pool.
 func (d *Db) Close() error {
 	if err := d.insert.Close(); err != nil {
 		return err
 	}
 
+	if err := d.saveCheckpoint.Close(); err != nil {
+		return err
+	}
+
+	if err := d.loadCheckpoint.Close(); err != nil {
+		return err
+	}
+
 	if err := d.db.Close(); err != nil {
 		return err
 	}
@@ -91,8 +220,8 @@ func (d *Db) createInsert(name string, row []string) {
 	query := `
 	INSERT INTO %s (%s)
 	VALUES (%s)
-	ON CONFLICT DO NOTHING`
-	query = fmt.Sprintf(query, name, strings.Join(row, ", "), values)
+	%s`
+	query = fmt.Sprintf(query, quote(name), quote(row...), values, d.conflictClause(name, row))
 
 	insert, err := d.db.Prepare(query)
 	if err != nil {
@@ -101,28 +230,200 @@ func (d *Db) createInsert(name string, row []string) {
 	d.insert = insert
 }
 
-func createTable(db *sql.DB, row []string, name string) {
+func (d *Db) createInsertBatch(name string, row []string) {
+	d.columns = row
+	d.staging = truncate(name, c.MaxIdentifierLen-len(c.StagingSuffix)) + c.StagingSuffix
+
+	// ord Row order into the batch.
+	query := `
+	CREATE TEMP TABLE %s
+	ON COMMIT DROP
+	AS SELECT 0::BIGINT AS %s, %s FROM %s
+	WITH NO DATA`
+	cols := quote(row...)
+	staging := quote(d.staging)
+	d.createStaging = fmt.Sprintf(query, staging, c.StagingOrd, cols, quote(name))
+
+	// A key could be repeated into a batch, but a row cannot be
+	// affected twice by the same INSERT. The first row is kept when it's
+	// skipped, the last one when it's overwritten.
+	sel := fmt.Sprintf("SELECT %s FROM %s", cols, staging)
+	if len(d.key) > 0 && d.onConflict != c.ConflictFail {
+		order := "ASC"
+		if d.onConflict == c.ConflictOverwrite {
+			order = "DESC"
+		}
+		key := quote(d.key...)
+		sel = fmt.Sprintf("SELECT DISTINCT ON (%s) %s FROM %s ORDER BY %s, %s %s", key, cols, staging, key, c.StagingOrd, order)
+	}
+
+	query = `
+	INSERT INTO %s (%s)
+	%s
+	%s`
+	d.merge = fmt.Sprintf(query, quote(name), cols, sel, d.conflictClause(name, row))
+}
+
+// conflictClause ON CONFLICT clause for the conflict policy.
+// An overwritten row is re-queued for the CRM only if it has changed.
+func (d *Db) conflictClause(name string, row []string) string {
+	switch d.onConflict {
+	case c.ConflictFail:
+		return ""
+	case c.ConflictOverwrite:
+		if len(d.key) == 0 {
+			break
+		}
+		var set, old, excluded []string
+		for _, col := range row {
+			if contains(d.key, col) {
+				continue
+			}
+			col = quote(col)
+			set = append(set, fmt.Sprintf("%s = EXCLUDED.%s", col, col))
+			old = append(old, quote(name)+"."+col)
+			excluded = append(excluded, "EXCLUDED."+col)
+		}
+		if len(set) == 0 {
+			break
+		}
+		return fmt.Sprintf(`ON CONFLICT (%s) DO UPDATE
+	SET %s, is_processed = FALSE, retry = 0, dead_letter = FALSE, next_attempt_at = NOW(),
+		idempotency_key = NULL, claimed_by = NULL, claimed_until = NULL
+	WHERE (%s) IS DISTINCT FROM (%s)`,
+			quote(d.key...), strings.Join(set, ", "), strings.Join(old, ", "), strings.Join(excluded, ", "))
+	}
+
+	if len(d.key) == 0 {
+		return "ON CONFLICT DO NOTHING"
+	}
+	return fmt.Sprintf("ON CONFLICT (%s) DO NOTHING", quote(d.key...))
+}
+
+func (d *Db) createCheckpoint(name string) {
+	query := `
+	INSERT INTO %s (table_name, byte_offset, line, updated_at)
+	VALUES ($1, $2, $3, NOW())
+	ON CONFLICT (table_name) DO UPDATE
+	SET byte_offset = EXCLUDED.byte_offset,
+		line = EXCLUDED.line,
+		updated_at = EXCLUDED.updated_at`
+	query = fmt.Sprintf(query, c.CheckpointTable)
+
+	save, err := d.db.Prepare(query)
+	if err != nil {
+		log.Fatalf("Couldn't create saveCheckpoint. Error: %s\n", err)
+	}
+	d.saveCheckpoint = save
+
+	query = `
+	SELECT byte_offset, line
+	FROM %s
+	WHERE table_name = $1`
+	query = fmt.Sprintf(query, c.CheckpointTable)
+
+	load, err := d.db.Prepare(query)
+	if err != nil {
+		log.Fatalf("Couldn't create loadCheckpoint. Error: %s\n", err)
+	}
+	d.loadCheckpoint = load
+}
+
+func createCheckpointTable(db *sql.DB) {
+	query := `CREATE TABLE IF NOT EXISTS %s (
+			table_name VARCHAR(255) PRIMARY KEY,
+			byte_offset BIGINT NOT NULL,
+			line INT NOT NULL,
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+			)`
+
+	if _, err := db.Exec(fmt.Sprintf(query, c.CheckpointTable)); err != nil {
+		log.Fatalf("Cannot create the %s Table. Error: %s\n", c.CheckpointTable, err)
+	}
+}
+
+// createTable Rows are unique by every column, unless a key is given.
+// Either is a unique index, so it could be set on an existing table.
+func createTable(db *sql.DB, schema Schema, name string, key []string) {
 	query := `CREATE TABLE IF NOT EXISTS %s (
 			id SERIAL PRIMARY KEY,
 			is_processed boolean DEFAULT FALSE,
 			retry int DEFAULT 0,
-			%s VARCHAR(255) NOT NULL,
-			UNIQUE(%s)
+			%s
 			)`
 
-	typeCol := strings.Join(row, " varchar(255) NOT NULL,\n")
-	unqCol := strings.Join(row, ", ")
-
-	query = fmt.Sprintf(query, name, typeCol, unqCol)
+	typeCol := schema.String()
+	query = fmt.Sprintf(query, quote(name), typeCol)
 
 	if _, err := db.Exec(query); err != nil {
 		log.Fatalf("Cannot create the %s Table. Error: %s\n", name, err)
 	}
+
+	// Rows state used by the CRM Integrator. Tables created by
+	// previous versions don't have them.
+	query = `ALTER TABLE %s
+			ADD COLUMN IF NOT EXISTS dead_letter boolean NOT NULL DEFAULT FALSE,
+			ADD COLUMN IF NOT EXISTS last_status int,
+			ADD COLUMN IF NOT EXISTS last_error text,
+			ADD COLUMN IF NOT EXISTS last_response text,
+			ADD COLUMN IF NOT EXISTS next_attempt_at timestamptz NOT NULL DEFAULT NOW(),
+			ADD COLUMN IF NOT EXISTS idempotency_key text,
+			ADD COLUMN IF NOT EXISTS claimed_by text,
+			ADD COLUMN IF NOT EXISTS claimed_until timestamptz`
+	if _, err := db.Exec(fmt.Sprintf(query, quote(name))); err != nil {
+		log.Fatalf("Cannot update the %s Table. Error: %s\n", name, err)
+	}
+
+	index := truncate(name+"_"+strings.Join(key, "_"), c.MaxIdentifierLen-len("_key")) + "_key"
+	cols := quote(key...)
+	if len(key) == 0 {
+		index = truncate(name+"_row", c.MaxIdentifierLen-len("_key")) + "_key"
+		cols = schema.uniqueKey()
+	}
+	query = `CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s)`
+	query = fmt.Sprintf(query, quote(index), quote(name), cols)
+	if _, err := db.Exec(query); err != nil {
+		log.Fatalf("Cannot create the %s Table's key. Error: %s\n", name, err)
+	}
+}
+
+// saveColumns Persist the original header of every column, so they could
+// be sent to the CRM as they were into the file.
+func (d *Db) saveColumns(name string, row, original []string) {
+	query := `
+	INSERT INTO %s (table_name, position, column_name, original_name)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (table_name, position) DO UPDATE
+	SET column_name = EXCLUDED.column_name,
+		original_name = EXCLUDED.original_name`
+	query = fmt.Sprintf(query, c.ColumnsTable)
+
+	for i := range row {
+		if _, err := d.db.Exec(query, name, i, row[i], original[i]); err != nil {
+			log.Fatalf("Cannot save the %s Table's columns. Error: %s\n", name, err)
+		}
+	}
+}
+
+func createColumnsTable(db *sql.DB) {
+	query := `CREATE TABLE IF NOT EXISTS %s (
+			table_name VARCHAR(255) NOT NULL,
+			position INT NOT NULL,
+			column_name VARCHAR(255) NOT NULL,
+			original_name TEXT NOT NULL,
+			PRIMARY KEY(table_name, position)
+			)`
+
+	if _, err := db.Exec(fmt.Sprintf(query, c.ColumnsTable)); err != nil {
+		log.Fatalf("Cannot create the %s Table. Error: %s\n", c.ColumnsTable, err)
+	}
 }
 
-func getDBName() string {
-	if rm := os.Getenv(c.RunMode); strings.ToUpper(rm) == c.Test {
-		return c.DbNameTest
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
 	}
-	return c.DbName
+	return false
 }
@@ -2,6 +2,18 @@ package database
 
 // DB represents available database operations
 type DB interface {
-	Insert(row ...string) error
+	// Insert A nil value is NULL.
+	Insert(row ...interface{}) error
+	// InsertBatch Insert every row or none.
+	InsertBatch(rows [][]interface{}) error
+	// SaveCheckpoint Persist the input position up to which every row
+	// has been inserted.
+	SaveCheckpoint(offset int64, line int) error
+	// LoadCheckpoint Last position saved. sql.ErrNoRows if there is none.
+	LoadCheckpoint() (offset int64, line int, err error)
+	// Register Save the import into the registry, as importing.
+	Register(source string, weight int) error
+	// SetImportStatus Update the import's status & row count.
+	SetImportStatus(status string) error
 	Close() error
 }
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// Identifier Normalize a name to be used as SQL identifier: snake_case
+// letters, digits and underscores, up to c.MaxIdentifierLen bytes.
+// e.g. "First Name" and "firstName" are first_name.
+// It's empty when name has neither letters nor digits.
+func Identifier(name string) string {
+	runes := []rune(strings.TrimSpace(name))
+	var b strings.Builder
+	// sep An underscore is pending, it's written before the next rune.
+	sep := false
+	for i, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			// firstName is first_name and HTTPCode is http_code.
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				sep = true
+			}
+			r = unicode.ToLower(r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+		default:
+			sep = true
+			continue
+		}
+		if sep && b.Len() > 0 {
+			b.WriteByte('_')
+		}
+		sep = false
+		b.WriteRune(r)
+	}
+	return truncate(b.String(), c.MaxIdentifierLen)
+}
+
+// ColumnNames Unique columns names for a header, prefixed by the table
+// name. Repeated names get a _2, _3... suffix and empty ones are column_N.
+func ColumnNames(table string, header []string) []string {
+	names := make([]string, len(header))
+	used := make(map[string]bool, len(header))
+	for i, col := range header {
+		col = Identifier(col)
+		if col == "" {
+			col = fmt.Sprintf("column_%d", i+1)
+		}
+		name := truncate(table+"_"+col, c.MaxIdentifierLen)
+		for n := 2; used[name]; n++ {
+			suffix := fmt.Sprintf("_%d", n)
+			name = truncate(table+"_"+col, c.MaxIdentifierLen-len(suffix)) + suffix
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// quote Quoted identifiers separated by comma.
+func quote(names ...string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = pq.QuoteIdentifier(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// truncate Cut s to n bytes, without cutting a rune.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// Register Save the import into the registry, which is watched by the
+// integrator daemon. An import of the same table, e.g. a resumed one, is
+// importing again.
+func (d *Db) Register(source string, weight int) error {
+	query := `
+	INSERT INTO %s (table_name, source, status, weight)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (table_name) DO UPDATE
+	SET source = EXCLUDED.source,
+		status = EXCLUDED.status,
+		weight = EXCLUDED.weight,
+		updated_at = NOW()`
+	_, err := d.db.Exec(fmt.Sprintf(query, c.ImportsTable), d.table, source, c.ImportImporting, weight)
+	return err
+}
+
+// SetImportStatus Update the import's status & row count.
+func (d *Db) SetImportStatus(status string) error {
+	query := `
+	UPDATE %s
+	SET status = $2, row_count = (SELECT count(*) FROM %s), updated_at = NOW()
+	WHERE table_name = $1`
+	query = fmt.Sprintf(query, c.ImportsTable, pq.QuoteIdentifier(d.table))
+	_, err := d.db.Exec(query, d.table, status)
+	return err
+}
+
+func createImportsTable(db *sql.DB) {
+	query := `CREATE TABLE IF NOT EXISTS %s (
+			table_name VARCHAR(255) PRIMARY KEY,
+			source TEXT NOT NULL,
+			row_count BIGINT NOT NULL DEFAULT 0,
+			status VARCHAR(16) NOT NULL,
+			weight INT NOT NULL DEFAULT 1,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+			)`
+
+	if _, err := db.Exec(fmt.Sprintf(query, c.ImportsTable)); err != nil {
+		log.Fatalf("Cannot create the %s Table. Error: %s\n", c.ImportsTable, err)
+	}
+}
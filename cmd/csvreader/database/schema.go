@@ -0,0 +1,269 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// Column Column's SQL type.
+type Column struct {
+	Name     string `json:"-"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// Schema Columns in file order.
+type Schema []Column
+
+// columnOverride Column as written into a schema file.
+// Nullable is optional to be able to override only the type.
+type columnOverride struct {
+	Type     string `json:"type"`
+	Nullable *bool  `json:"nullable"`
+}
+
+var dateLayouts = []string{"2006-01-02", "2006/01/02"}
+
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+}
+
+var booleans = map[string]bool{
+	"true": true, "t": true, "yes": true, "y": true,
+	"false": false, "f": false, "no": false, "n": false,
+}
+
+// types Types tried by inference, from the most specific one.
+// TEXT always matches.
+var types = []string{c.TypeBoolean, c.TypeInteger, c.TypeBigInt, c.TypeNumeric, c.TypeDate, c.TypeTimestamp}
+
+// TextSchema Every column as TEXT NOT NULL.
+func TextSchema(columns []string) Schema {
+	s := make(Schema, len(columns))
+	for i, col := range columns {
+		s[i] = Column{Name: col, Type: c.TypeText}
+	}
+	return s
+}
+
+// InferSchema Propose a type for each column from a rows sample.
+// Typed columns are nullable, as rows after the sample could have empty
+// values. TEXT columns aren't: an empty string is a valid TEXT.
+func InferSchema(columns []string, rows [][]string) Schema {
+	s := make(Schema, len(columns))
+	for i, col := range columns {
+		s[i] = Column{Name: col, Type: c.TypeText}
+		candidates := append([]string(nil), types...)
+		values := 0
+		for _, row := range rows {
+			if i >= len(row) || strings.TrimSpace(row[i]) == "" {
+				continue
+			}
+			values++
+			candidates = matching(candidates, row[i])
+		}
+		if values > 0 && len(candidates) > 0 {
+			s[i].Type = candidates[0]
+		}
+		s[i].Nullable = s[i].Type != c.TypeText
+	}
+	return s
+}
+
+func matching(candidates []string, value string) []string {
+	m := candidates[:0]
+	for _, t := range candidates {
+		if _, err := convert(t, value); err == nil {
+			m = append(m, t)
+		}
+	}
+	return m
+}
+
+// Override Override s with the columns into a JSON file, e.g.
+// {"id": {"type": "INTEGER", "nullable": false}}
+func (s Schema) Override(path string) (Schema, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Cannot open schema file: %s\n", path)
+		return nil, err
+	}
+	defer file.Close()
+
+	overrides := make(map[string]columnOverride)
+	if err := json.NewDecoder(file).Decode(&overrides); err != nil {
+		log.Printf("Cannot decode schema file: %s\n", path)
+		return nil, err
+	}
+
+	overridden := append(Schema(nil), s...)
+	for name, o := range overrides {
+		i := overridden.index(name)
+		if i < 0 {
+			log.Printf("Schema column not found: %s\n", name)
+			return nil, errors.New(c.ErrColumnNotFound)
+		}
+		if o.Type != "" {
+			t := strings.ToUpper(o.Type)
+			if !validType(t) {
+				log.Printf("Schema type unknown: %s\n", o.Type)
+				return nil, errors.New(c.ErrSchemaType)
+			}
+			overridden[i].Type = t
+		}
+		if o.Nullable != nil {
+			overridden[i].Nullable = *o.Nullable
+		}
+	}
+	return overridden, nil
+}
+
+// Convert Convert a row to its columns types. Empty values are NULL
+// into nullable columns.
+func (s Schema) Convert(row []string) ([]interface{}, error) {
+	values := make([]interface{}, len(row))
+	for i, v := range row {
+		if i >= len(s) {
+			values[i] = v
+			continue
+		}
+		col := s[i]
+		if strings.TrimSpace(v) == "" && (col.Nullable || col.Type != c.TypeText) {
+			if !col.Nullable {
+				return nil, fmt.Errorf("%s: %s is empty", c.ErrConversion, col.Name)
+			}
+			values[i] = nil
+			continue
+		}
+		converted, err := convert(col.Type, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s %q is not %s", c.ErrConversion, col.Name, v, col.Type)
+		}
+		values[i] = converted
+	}
+	return values, nil
+}
+
+// String Schema as SQL columns definition, with quoted names.
+func (s Schema) String() string {
+	defs := make([]string, len(s))
+	for i, col := range s {
+		defs[i] = fmt.Sprintf("%s %s", quote(col.Name), col.Type)
+		if !col.Nullable {
+			defs[i] += " NOT NULL"
+		}
+	}
+	return strings.Join(defs, ", ")
+}
+
+// uniqueKey Index expressions which make rows unique. Postgres takes NULLs
+// as distinct, so they are compared as empty strings: rows with the same
+// empty values are duplicates.
+func (s Schema) uniqueKey() string {
+	exprs := make([]string, len(s))
+	for i, col := range s {
+		exprs[i] = quote(col.Name)
+		if col.Nullable {
+			exprs[i] = fmt.Sprintf("(COALESCE(%s::text, ''))", exprs[i])
+		}
+	}
+	return strings.Join(exprs, ", ")
+}
+
+// Names Columns names.
+func (s Schema) Names() []string {
+	names := make([]string, len(s))
+	for i, col := range s {
+		names[i] = col.Name
+	}
+	return names
+}
+
+func (s Schema) index(name string) int {
+	for i, col := range s {
+		if col.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func validType(t string) bool {
+	for _, known := range types {
+		if t == known {
+			return true
+		}
+	}
+	return t == c.TypeText
+}
+
+// convert A value as Postgres expects it for a type.
+// TEXT values are kept as they are.
+func convert(t, value string) (string, error) {
+	v := strings.TrimSpace(value)
+	switch t {
+	case c.TypeBoolean:
+		b, ok := booleans[strings.ToLower(v)]
+		if !ok {
+			return "", errors.New(c.ErrConversion)
+		}
+		return strconv.FormatBool(b), nil
+	case c.TypeInteger, c.TypeBigInt:
+		bits := 64
+		if t == c.TypeInteger {
+			bits = 32
+		}
+		if leadingZero(v) {
+			return "", errors.New(c.ErrConversion)
+		}
+		_, err := strconv.ParseInt(v, 10, bits)
+		return v, err
+	case c.TypeNumeric:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) || leadingZero(v) {
+			return "", errors.New(c.ErrConversion)
+		}
+		return v, nil
+	case c.TypeDate:
+		d, err := parseTime(dateLayouts, v)
+		if err != nil {
+			return "", err
+		}
+		return d.Format("2006-01-02"), nil
+	case c.TypeTimestamp:
+		ts, err := parseTime(timestampLayouts, v)
+		if err != nil {
+			return "", err
+		}
+		return ts.UTC().Format("2006-01-02 15:04:05.999999999"), nil
+	}
+	return value, nil
+}
+
+// leadingZero Values like zip codes ("00123") would lose their zeros as
+// numbers.
+func leadingZero(v string) bool {
+	v = strings.TrimLeft(v, "+-")
+	return len(v) > 1 && v[0] == '0' && v[1] != '.'
+}
+
+func parseTime(layouts []string, v string) (time.Time, error) {
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New(c.ErrConversion)
+}
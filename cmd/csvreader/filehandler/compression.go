@@ -0,0 +1,132 @@
+package filehandler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/josesolana/csv-reader/constants"
+)
+
+// Decompressor wraps a compressed stream into a decompressed one.
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+// compression Known compressed format. It is detected by its magic bytes,
+// and, when magic bytes aren't enough, by its file extension.
+type compression struct {
+	name  string
+	ext   string
+	magic []byte
+	open  Decompressor
+	// next Bytes which could follow magic. Any one if empty.
+	next []byte
+}
+
+var compressions = []*compression{
+	{
+		name:  "gzip",
+		ext:   constants.GzipExt,
+		magic: []byte{0x1f, 0x8b},
+		open: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	{
+		name:  "bzip2",
+		ext:   constants.Bzip2Ext,
+		magic: []byte("BZh"),
+		open: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bzip2.NewReader(r)), nil
+		},
+		// Block size, in 100 KB.
+		next: []byte("123456789"),
+	},
+	{
+		name:  "zstd",
+		ext:   constants.ZstdExt,
+		magic: []byte{0x28, 0xb5, 0x2f, 0xfd},
+		open: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	},
+}
+
+// RegisterDecompressor Set the Decompressor used by a known format
+// (gzip, bzip2 or zstd).
+func RegisterDecompressor(name string, d Decompressor) error {
+	for _, c := range compressions {
+		if c.name == name {
+			c.open = d
+			return nil
+		}
+	}
+	return errors.New(constants.ErrCompressionUnknown)
+}
+
+// decompress Detect the compression used by r and returns a stream which
+// decompress it on the fly. Nothing is decompressed to disk or memory
+// beyond the reader's buffer. It also returns whether r is compressed.
+func decompress(r io.Reader, name string) (io.ReadCloser, bool, error) {
+	br := bufio.NewReader(r)
+	c := detectCompression(br, name)
+	if c == nil {
+		return ioutil.NopCloser(br), false, nil
+	}
+
+	if c.open == nil {
+		log.Printf("There is no decompressor registered for %s\n", c.name)
+		return nil, true, errors.New(constants.ErrCompressionUnsupported)
+	}
+	log.Printf("Reading %s compressed file\n", c.name)
+	rc, err := c.open(br)
+	return rc, true, err
+}
+
+func detectCompression(br *bufio.Reader, name string) *compression {
+	for _, c := range compressions {
+		if c.detect(br) {
+			return c
+		}
+	}
+	for _, c := range compressions {
+		if strings.HasSuffix(name, c.ext) {
+			return c
+		}
+	}
+	return nil
+}
+
+// detect Whether br starts by the magic bytes.
+func (c *compression) detect(br *bufio.Reader) bool {
+	n := len(c.magic)
+	if len(c.next) > 0 {
+		n++
+	}
+	head, _ := br.Peek(n)
+	if len(head) < n || !bytes.HasPrefix(head, c.magic) {
+		return false
+	}
+	return len(c.next) == 0 || bytes.IndexByte(c.next, head[n-1]) >= 0
+}
+
+// trimCompressionExt Removes a compression extension, if any.
+func trimCompressionExt(name string) string {
+	for _, c := range compressions {
+		if strings.HasSuffix(name, c.ext) {
+			return strings.TrimSuffix(name, c.ext)
+		}
+	}
+	return name
+}
@@ -0,0 +1,213 @@
+package filehandler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/josesolana/csv-reader/constants"
+)
+
+// sniffDelimiters Delimiters taken into account by auto detection.
+var sniffDelimiters = []rune{',', ';', '\t', '|'}
+
+// sniffComments Comment characters taken into account by auto detection.
+var sniffComments = []rune{'#'}
+
+// Dialect How a CSV file has been written.
+// The quote character cannot be set: encoding/csv only supports '"'.
+type Dialect struct {
+	// Comma Field delimiter.
+	Comma rune
+	// Comment Lines beginning with it are ignored. 0 to disable it.
+	Comment rune
+	// LazyQuotes A quote may appear in an unquoted field and a
+	// non-doubled quote may appear in a quoted field.
+	LazyQuotes bool
+	// TrimLeadingSpace Leading white space in a field is ignored.
+	TrimLeadingSpace bool
+	// Header First row has column names. Otherwise they are generated.
+	Header bool
+	// AutoDetect Sniff delimiter, comment and header from the first
+	// constants.SniffSize bytes. Values above are used as fallback.
+	AutoDetect bool
+	// Encoding Character encoding, transcoded to UTF-8 before parsing.
+	// constants.EncodingAuto to detect it. A BOM always wins.
+	Encoding string
+}
+
+// DefaultDialect RFC 4180 UTF-8 with a header row.
+var DefaultDialect = Dialect{
+	Comma:    ',',
+	Header:   true,
+	Encoding: constants.EncodingUTF8,
+}
+
+// ParseDelimiter Parse a delimiter given by the user. It accepts a single
+// character or its name: comma, semicolon, tab or pipe. "\t" is also valid.
+func ParseDelimiter(s string) (rune, error) {
+	switch strings.ToLower(s) {
+	case "comma":
+		return ',', nil
+	case "semicolon":
+		return ';', nil
+	case "tab", `\t`:
+		return '\t', nil
+	case "pipe":
+		return '|', nil
+	case "":
+		return 0, nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) {
+		return 0, fmt.Errorf("%s: %q", constants.ErrDelimiterInvalid, s)
+	}
+	return r, nil
+}
+
+// Validate Check the Dialect could be used by a csv.Reader.
+func (d Dialect) Validate() error {
+	if d.Comma == d.Comment {
+		return errors.New(constants.ErrDialectInvalid)
+	}
+	if !validDelim(d.Comma) || (d.Comment != 0 && !validDelim(d.Comment)) {
+		return errors.New(constants.ErrDialectInvalid)
+	}
+	return nil
+}
+
+func validDelim(r rune) bool {
+	return r != 0 && r != '"' && r != '\r' && r != '\n' && utf8.ValidRune(r) && r != utf8.RuneError
+}
+
+func (d Dialect) apply(r *csv.Reader) {
+	r.Comma = d.Comma
+	r.Comment = d.Comment
+	r.LazyQuotes = d.LazyQuotes
+	r.TrimLeadingSpace = d.TrimLeadingSpace
+}
+
+// Sniff Guess the Dialect from a file's head.
+// Any value which cannot be guessed is taken from d.
+func (d Dialect) Sniff(head []byte) Dialect {
+	// Partial last line is discarded, unless it's the only one.
+	if i := bytes.LastIndexByte(head, '\n'); i > 0 {
+		head = head[:i]
+	}
+	lines := strings.Split(strings.Replace(string(head), "\r\n", "\n", -1), "\n")
+
+	sniffed := d
+	sniffed.Comment = sniffComment(lines, d.Comment)
+	lines = dataLines(lines, sniffed.Comment)
+	sniffed.Comma = sniffDelimiter(lines, d.Comma)
+	sniffed.Header = sniffHeader(lines, sniffed, d.Header)
+	return sniffed
+}
+
+func sniffComment(lines []string, fallback rune) rune {
+	for _, c := range sniffComments {
+		for _, l := range lines {
+			if strings.HasPrefix(l, string(c)) {
+				return c
+			}
+		}
+	}
+	return fallback
+}
+
+func dataLines(lines []string, comment rune) []string {
+	data := make([]string, 0, len(lines))
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" || (comment != 0 && strings.HasPrefix(l, string(comment))) {
+			continue
+		}
+		data = append(data, l)
+	}
+	return data
+}
+
+// sniffDelimiter The delimiter chosen is which appears the same number of
+// times in most lines. Ties are broken by sniffDelimiters order.
+func sniffDelimiter(lines []string, fallback rune) rune {
+	best, bestScore := fallback, 0
+	for _, delim := range sniffDelimiters {
+		freq := make(map[int]int)
+		for _, l := range lines {
+			if n := strings.Count(l, string(delim)); n > 0 {
+				freq[n]++
+			}
+		}
+		score := 0
+		for _, lines := range freq {
+			if lines > score {
+				score = lines
+			}
+		}
+		if score > bestScore {
+			best, bestScore = delim, score
+		}
+	}
+	return best
+}
+
+// sniffHeader A header is assumed when a column has numbers in every row
+// but the first one.
+func sniffHeader(lines []string, d Dialect, fallback bool) bool {
+	if len(lines) < 2 {
+		return fallback
+	}
+	r := csv.NewReader(strings.NewReader(strings.Join(lines, "\n")))
+	d.Comment = 0
+	d.LazyQuotes = true
+	d.apply(r)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil || len(rows) < 2 {
+		return fallback
+	}
+
+	votes := 0
+	for col := range rows[0] {
+		numeric := true
+		for _, row := range rows[1:] {
+			if col >= len(row) || !isNumber(row[col]) {
+				numeric = false
+				break
+			}
+		}
+		if !numeric {
+			continue
+		}
+		if isNumber(rows[0][col]) {
+			votes--
+		} else {
+			votes++
+		}
+	}
+
+	switch {
+	case votes > 0:
+		return true
+	case votes < 0:
+		return false
+	}
+	return fallback
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return err == nil
+}
+
+// columnNames Names used when a file has no header.
+func columnNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("column_%d", i+1)
+	}
+	return names
+}
@@ -0,0 +1,327 @@
+package filehandler
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/josesolana/csv-reader/constants"
+)
+
+// decodeRune Reads a rune from a stream. Invalid sequences are returned
+// as utf8.RuneError with invalid set.
+type decodeRune func(r *bufio.Reader) (c rune, invalid bool, err error)
+
+var decoders = map[string]decodeRune{
+	constants.EncodingUTF8:        decodeUTF8,
+	constants.EncodingUTF16LE:     decodeUTF16(false),
+	constants.EncodingUTF16BE:     decodeUTF16(true),
+	constants.EncodingISO88591:    decodeISO88591,
+	constants.EncodingWindows1252: decodeWindows1252,
+}
+
+var encodingAliases = map[string]string{
+	"utf8":     constants.EncodingUTF8,
+	"utf16le":  constants.EncodingUTF16LE,
+	"utf16be":  constants.EncodingUTF16BE,
+	"latin1":   constants.EncodingISO88591,
+	"latin-1":  constants.EncodingISO88591,
+	"cp1252":   constants.EncodingWindows1252,
+	"win-1252": constants.EncodingWindows1252,
+}
+
+var boms = []struct {
+	bom      []byte
+	encoding string
+}{
+	{[]byte{0xef, 0xbb, 0xbf}, constants.EncodingUTF8},
+	{[]byte{0xff, 0xfe}, constants.EncodingUTF16LE},
+	{[]byte{0xfe, 0xff}, constants.EncodingUTF16BE},
+}
+
+// ParseEncoding Normalize an encoding name given by the user.
+// An empty name means auto detection.
+func ParseEncoding(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := encodingAliases[name]; ok {
+		name = alias
+	}
+	if _, ok := decoders[name]; ok || name == constants.EncodingAuto {
+		return name, nil
+	}
+	if name == "" {
+		return constants.EncodingAuto, nil
+	}
+	return "", errors.New(constants.ErrEncodingUnknown)
+}
+
+// transcoder Converts a stream to UTF-8 on the fly.
+// Invalid sequences are replaced by U+FFFD and logged with their line.
+type transcoder struct {
+	src      *bufio.Reader
+	encoding string
+	decode   decodeRune
+	line     int
+	invalid  int
+	pending  []byte
+	err      error
+
+	// shift Input offset minus output offset at start, e.g. a BOM length.
+	shift int64
+	// written Output offset.
+	written int64
+	// replaced Output offsets where an invalid UTF-8 byte has been replaced
+	// by U+FFFD, which is 2 bytes longer. Used to map offsets to the input.
+	replaced []int64
+}
+
+// transcode Strip the BOM, if any, and returns a UTF-8 stream.
+// A BOM always wins over the given encoding.
+func transcode(r io.Reader, encoding string) (io.Reader, error) {
+	src := bufio.NewReaderSize(r, constants.SniffSize)
+
+	bomEnc, bom := stripBOM(src)
+	switch {
+	case bomEnc != "" && encoding != bomEnc && encoding != constants.EncodingAuto:
+		log.Printf("BOM found. Encoding %s is used instead of %s\n", bomEnc, encoding)
+		encoding = bomEnc
+	case bomEnc != "":
+		encoding = bomEnc
+	case encoding == constants.EncodingAuto:
+		head, _ := src.Peek(constants.SniffSize)
+		encoding = detectEncoding(head)
+		log.Printf("Encoding detected: %s\n", encoding)
+	}
+
+	decode, ok := decoders[encoding]
+	if !ok {
+		return nil, errors.New(constants.ErrEncodingUnknown)
+	}
+	return &transcoder{
+		src:      src,
+		encoding: encoding,
+		decode:   decode,
+		line:     1,
+		shift:    int64(bom),
+	}, nil
+}
+
+// inputOffset Input offset for a UTF-8 output offset.
+// It's only known when the input is UTF-8 as well.
+func (t *transcoder) inputOffset(offset int64) (int64, bool) {
+	if t.encoding != constants.EncodingUTF8 {
+		return 0, false
+	}
+	input := t.shift + offset
+	for _, r := range t.replaced {
+		if r >= offset {
+			break
+		}
+		input -= int64(utf8.RuneLen(utf8.RuneError) - 1)
+	}
+	return input, true
+}
+
+func (t *transcoder) Read(p []byte) (int, error) {
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+
+	var buf [utf8.UTFMax]byte
+	for n < len(p) && t.err == nil {
+		if t.encoding == constants.EncodingUTF8 {
+			if m := t.copyValid(p[n:]); m > 0 {
+				n += m
+				continue
+			}
+		}
+		c, invalid, err := t.decode(t.src)
+		if err != nil {
+			t.err = err
+			break
+		}
+		if invalid {
+			t.invalid++
+			log.Printf("Invalid %s sequence at line %d\n", t.encoding, t.line)
+			if t.encoding == constants.EncodingUTF8 {
+				t.replaced = append(t.replaced, t.written)
+			}
+		}
+		if c == '\n' {
+			t.line++
+		}
+
+		size := utf8.EncodeRune(buf[:], c)
+		copied := copy(p[n:], buf[:size])
+		t.pending = append(t.pending, buf[copied:size]...)
+		n += copied
+		t.written += int64(size)
+	}
+
+	if n > 0 {
+		return n, nil
+	}
+	if t.err == io.EOF && t.invalid > 0 {
+		log.Printf("%d invalid %s sequences have been replaced\n", t.invalid, t.encoding)
+		t.invalid = 0
+	}
+	return 0, t.err
+}
+
+// copyValid Copy buffered UTF-8 as it is, up to the first invalid or
+// incomplete sequence, which is left to decode. It returns the bytes
+// copied.
+func (t *transcoder) copyValid(p []byte) int {
+	if t.src.Buffered() == 0 {
+		if _, err := t.src.Peek(1); err != nil {
+			return 0
+		}
+	}
+	head, _ := t.src.Peek(t.src.Buffered())
+	if len(head) > len(p) {
+		head = head[:len(p)]
+	}
+
+	valid := 0
+	for valid < len(head) {
+		if head[valid] < utf8.RuneSelf {
+			valid++
+			continue
+		}
+		c, size := utf8.DecodeRune(head[valid:])
+		if c == utf8.RuneError && size == 1 {
+			break
+		}
+		valid += size
+	}
+
+	copy(p, head[:valid])
+	t.line += bytes.Count(head[:valid], []byte{'\n'})
+	t.written += int64(valid)
+	t.src.Discard(valid)
+	return valid
+}
+
+func stripBOM(r *bufio.Reader) (string, int) {
+	for _, b := range boms {
+		if head, _ := r.Peek(len(b.bom)); bytes.Equal(head, b.bom) {
+			r.Discard(len(b.bom))
+			return b.encoding, len(b.bom)
+		}
+	}
+	return "", 0
+}
+
+// detectEncoding Guess an encoding without BOM. UTF-16 is detected by its
+// zero bytes, then valid UTF-8 is assumed, and finally Windows-1252 since
+// it's a superset of ISO-8859-1 printable characters.
+func detectEncoding(head []byte) string {
+	var even, odd int
+	for i, b := range head {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			even++
+		} else {
+			odd++
+		}
+	}
+	switch {
+	case odd > len(head)/4 && odd > even:
+		return constants.EncodingUTF16LE
+	case even > len(head)/4:
+		return constants.EncodingUTF16BE
+	case validUTF8Prefix(head):
+		return constants.EncodingUTF8
+	}
+	return constants.EncodingWindows1252
+}
+
+// validUTF8Prefix A rune cut by the sniff size is not taken as invalid.
+func validUTF8Prefix(b []byte) bool {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				b = b[:len(b)-i]
+			}
+			break
+		}
+	}
+	return utf8.Valid(b)
+}
+
+func decodeUTF8(r *bufio.Reader) (rune, bool, error) {
+	c, size, err := r.ReadRune()
+	return c, err == nil && c == utf8.RuneError && size == 1, err
+}
+
+func decodeUTF16(bigEndian bool) decodeRune {
+	unit := func(r *bufio.Reader) (rune, error) {
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		if bigEndian {
+			return rune(b[0])<<8 | rune(b[1]), nil
+		}
+		return rune(b[1])<<8 | rune(b[0]), nil
+	}
+
+	return func(r *bufio.Reader) (rune, bool, error) {
+		c, err := unit(r)
+		if err == io.ErrUnexpectedEOF {
+			return utf8.RuneError, true, nil
+		} else if err != nil {
+			return 0, false, err
+		}
+		if !utf16.IsSurrogate(c) {
+			return c, false, nil
+		}
+
+		// A low surrogate is peeked to not lose the next rune if it's invalid.
+		next, _ := r.Peek(2)
+		if len(next) < 2 {
+			return utf8.RuneError, true, nil
+		}
+		var low rune
+		if bigEndian {
+			low = rune(next[0])<<8 | rune(next[1])
+		} else {
+			low = rune(next[1])<<8 | rune(next[0])
+		}
+		if dec := utf16.DecodeRune(c, low); dec != utf8.RuneError {
+			r.Discard(2)
+			return dec, false, nil
+		}
+		return utf8.RuneError, true, nil
+	}
+}
+
+func decodeISO88591(r *bufio.Reader) (rune, bool, error) {
+	b, err := r.ReadByte()
+	return rune(b), false, err
+}
+
+// windows1252 Characters from 0x80 to 0x9F. Zero values are undefined.
+var windows1252 = [32]rune{
+	0x20ac, 0, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017d, 0,
+	0, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0, 0x017e, 0x0178,
+}
+
+func decodeWindows1252(r *bufio.Reader) (rune, bool, error) {
+	b, err := r.ReadByte()
+	if err != nil || b < 0x80 || b > 0x9f {
+		return rune(b), false, err
+	}
+	if c := windows1252[b-0x80]; c != 0 {
+		return c, false, nil
+	}
+	return utf8.RuneError, true, nil
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/csv"
 	"errors"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -14,11 +15,31 @@ import (
 // FileHandler Wrappeer to read files.
 type FileHandler struct {
 	reader *csv.Reader
-	file   *os.File
+	source io.ReadCloser
+	// file Is nil when the handler doesn't own the underlying stream.
+	file io.Closer
+	// noHeader Column names are generated by the first Read,
+	// and first is returned by the next one.
+	noHeader bool
+	first    []string
+
+	dialect Dialect
+	text    *transcoder
+	// seeker Set when the input could be seek to resume.
+	seeker io.Seeker
+	// base Position where reader has started.
+	base Position
+	// line Last line read by reader.
+	line int
 }
 
 // NewFileHandler Filer Handler
 func NewFileHandler(name string) (Readable, error) {
+	return NewFileHandlerWithDialect(name, DefaultDialect)
+}
+
+// NewFileHandlerWithDialect Filer Handler for a file written in a dialect.
+func NewFileHandlerWithDialect(name string, dialect Dialect) (Readable, error) {
 	filePath, err := GetFilePath(name)
 	if err != nil {
 		return nil, err
@@ -30,34 +51,187 @@ func NewFileHandler(name string) (Readable, error) {
 		return nil, err
 	}
 
-	reader := csv.NewReader(bufio.NewReader(file))
-	reader.FieldsPerRecord = 0
+	f, err := newHandler(file, filePath, dialect)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f.file = file
+	return f, nil
+}
+
+// NewReaderHandler Handler over an arbitrary stream, e.g. Stdin.
+// The name is only used to detect compression and for logging.
+// Closing the handler doesn't close r.
+func NewReaderHandler(r io.Reader, name string) (Readable, error) {
+	return NewReaderHandlerWithDialect(r, name, DefaultDialect)
+}
+
+// NewReaderHandlerWithDialect Handler over an arbitrary stream written in
+// a dialect.
+func NewReaderHandlerWithDialect(r io.Reader, name string, dialect Dialect) (Readable, error) {
+	return newHandler(r, name, dialect)
+}
+
+func newHandler(r io.Reader, name string, dialect Dialect) (*FileHandler, error) {
+	source, compressed, err := decompress(r, name)
+	if err != nil {
+		log.Printf("Cannot decompress: %s", name)
+		return nil, err
+	}
+
+	text, err := transcode(source, dialect.Encoding)
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
 
+	buf := bufio.NewReaderSize(text, constants.SniffSize)
+	if dialect.AutoDetect {
+		head, _ := buf.Peek(constants.SniffSize)
+		dialect = dialect.Sniff(head)
+		log.Printf("Dialect detected: %+q Comment: %+q Header: %t\n", dialect.Comma, dialect.Comment, dialect.Header)
+	}
+	if err := dialect.Validate(); err != nil {
+		source.Close()
+		return nil, err
+	}
+
+	f := &FileHandler{
+		source:   source,
+		noHeader: !dialect.Header,
+		dialect:  dialect,
+		text:     text.(*transcoder),
+	}
+	// Pipes, such as stdin, are *os.File but cannot seek.
+	if seeker, ok := r.(io.Seeker); ok && !compressed {
+		if _, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			f.seeker = seeker
+		}
+	}
+	f.setReader(buf)
+	return f, nil
+}
 
-	return &FileHandler{
-		file:   file,
-		reader: reader,
-	}, nil
+func (f *FileHandler) setReader(r io.Reader) {
+	f.reader = csv.NewReader(r)
+	f.reader.FieldsPerRecord = 0
+	f.dialect.apply(f.reader)
+}
+
+// Position Where the last row read ends.
+func (f *FileHandler) Position() Position {
+	return Position{
+		Offset: f.base.Offset + f.reader.InputOffset(),
+		Line:   f.base.Line + f.line,
+	}
+}
+
+// Resume Skip every row up to pos. Plain UTF-8 files are seek,
+// otherwise rows are read and discarded.
+// It should be called after the header has been read.
+func (f *FileHandler) Resume(pos Position) error {
+	cur := f.Position().Offset
+	if pos.Offset < cur {
+		return nil
+	}
+	f.first = nil
+	if pos.Offset == cur {
+		return nil
+	}
+
+	if input, ok := f.text.inputOffset(pos.Offset); ok && f.seeker != nil {
+		return f.seek(input, pos)
+	}
+
+	log.Printf("Skipping rows up to line %d\n", pos.Line)
+	for f.Position().Offset < pos.Offset {
+		if _, err := f.read(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *FileHandler) seek(input int64, pos Position) error {
+	log.Printf("Seeking up to line %d\n", pos.Line)
+	if _, err := f.seeker.Seek(input, io.SeekStart); err != nil {
+		return err
+	}
+
+	r, ok := f.seeker.(io.Reader)
+	if !ok {
+		return errors.New(constants.ErrResume)
+	}
+	f.text = &transcoder{
+		src:      bufio.NewReaderSize(r, constants.SniffSize),
+		encoding: constants.EncodingUTF8,
+		decode:   decodeUTF8,
+		line:     pos.Line + 1,
+		shift:    input - pos.Offset,
+		written:  pos.Offset,
+	}
+	f.base = pos
+	f.line = 0
+	f.setReader(bufio.NewReaderSize(f.text, constants.SniffSize))
+	return nil
 }
 
 // Close closes the File, rendering it unusable for I/O.
 // It returns an error, if any.
 func (f *FileHandler) Close() error {
-	return f.file.Close()
+	err := f.source.Close()
+	if f.file == nil {
+		return err
+	}
+	if errFile := f.file.Close(); err == nil {
+		err = errFile
+	}
+	return err
 }
 
 // Close closes the File, rendering it unusable for I/O.
 // It returns an error, if any.
 func (f *FileHandler) Read() ([]string, error) {
-	return f.reader.Read()
+	if f.noHeader {
+		f.noHeader = false
+		first, err := f.read()
+		if err != nil {
+			return nil, err
+		}
+		f.first = first
+		return columnNames(len(first)), nil
+	}
+	if f.first != nil {
+		first := f.first
+		f.first = nil
+		return first, nil
+	}
+	return f.read()
+}
+
+func (f *FileHandler) read() ([]string, error) {
+	row, err := f.reader.Read()
+	if err == nil {
+		f.line, _ = f.reader.FieldPos(len(row) - 1)
+	}
+	return row, err
 }
 
 //GetFilePath Fetch the path for a file.
+// A CSV file could be compressed (.csv.gz, .csv.bz2 or .csv.zst).
 func GetFilePath(fileName string) (string, error) {
-	if filepath.Ext(fileName) != constants.AcceptedExt {
-		log.Printf("File type not accepted: %s\n", filepath.Ext(fileName))
+	if ext := filepath.Ext(trimCompressionExt(fileName)); ext != constants.AcceptedExt {
+		log.Printf("File type not accepted: %s\n", ext)
 		return "", errors.New(constants.ErrExtensionFile)
 	}
+	if filepath.IsAbs(fileName) {
+		return filepath.Clean(fileName), nil
+	}
 	wd, err := os.Getwd()
 	if err != nil {
 		log.Println(constants.ErrWorkingDirectory)
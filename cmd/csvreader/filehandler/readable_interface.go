@@ -3,5 +3,18 @@ package filehandler
 // Readable Interface to wraps CSV Reader
 type Readable interface {
 	Read() ([]string, error)
+	// Position Where the last row read ends.
+	Position() Position
+	// Resume Skip every row up to a Position given by Position().
+	Resume(pos Position) error
 	Close() error
 }
+
+// Position Location into the input, after the file has been decompressed
+// and transcoded to UTF-8.
+type Position struct {
+	// Offset Bytes from the beginning. The next row starts here.
+	Offset int64
+	// Line Last line read.
+	Line int
+}
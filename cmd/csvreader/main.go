@@ -1,25 +1,149 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"os"
 
 	_ "github.com/lib/pq"
 
+	fh "github.com/josesolana/csv-reader/cmd/csvreader/filehandler"
 	"github.com/josesolana/csv-reader/cmd/csvreader/processor"
+	"github.com/josesolana/csv-reader/config"
+	"github.com/josesolana/csv-reader/constants"
+	"github.com/josesolana/csv-reader/ipc"
 )
 
+// stdin File name used to read from Stdin.
+const stdin = "-"
+
 func main() {
-	if len(os.Args) == 1 {
-		log.Fatalf("Filename should be provided")
+	table := flag.String("table", "", "Table to migrate into. By default it's the file name. Required to read from Stdin")
+	delimiter := flag.String("delimiter", ",", "Field delimiter. A character or comma, semicolon, tab, pipe")
+	comment := flag.String("comment", "", "Lines beginning with this character are ignored")
+	encoding := flag.String("encoding", fh.DefaultDialect.Encoding, "utf-8, utf-16le, utf-16be, iso-8859-1, windows-1252 or auto")
+	dialect := fh.DefaultDialect
+	flag.BoolVar(&dialect.LazyQuotes, "lazy-quotes", false, "Allow quotes in unquoted fields and non-doubled quotes in quoted fields")
+	flag.BoolVar(&dialect.TrimLeadingSpace, "trim-leading-space", false, "Ignore leading white space in a field")
+	flag.BoolVar(&dialect.Header, "header", true, "First row has column names")
+	flag.BoolVar(&dialect.AutoDetect, "auto-detect", false, "Detect delimiter, comment and header from the first KB")
+	resume := flag.Bool("resume", false, "Resume a previous import from its last checkpoint")
+	shard := flag.String("shard", constants.ShardRoundRobin, "How rows are balanced between workers: round-robin, least-loaded or hash")
+	shardKey := flag.String("shard-key", "", "Comma separated key columns hashed by --shard hash. By default it's --key")
+	sampleRows := flag.Int("sample", constants.SampleRows, "Rows read to infer the columns types")
+	schema := flag.String("schema", "", "JSON file overriding inferred columns types, e.g. {\"id\": {\"type\": \"INTEGER\"}}")
+	key := flag.String("key", "", "Comma separated columns which identify a row. By default, every column")
+	onConflict := flag.String("on-conflict", constants.ConflictSkip, "What to do with a row whose key already exists: skip, overwrite (and send it again to the CRM) or fail")
+	weight := flag.Int("weight", constants.ImportWeight, "Batches the integrator daemon sends from this table for every batch of a table of weight 1")
+	wait := flag.Bool("wait", false, "Wait until the integrator connected by ipc_socket has sent every row")
+	batchSize := flag.Int("batch-size", constants.InsertBatchRows, "Rows inserted at once through COPY by each worker. 1 inserts row by row")
+	loader := config.NewLoader(constants.CSVReaderEnv, config.CSVReader, flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatalf("Fatal Error: %s\n", err)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatalf("Filename should be provided. Use %s to read from Stdin", stdin)
+	}
+	name := flag.Arg(0)
+
+	if dialect.Comma, err = fh.ParseDelimiter(*delimiter); err != nil {
+		log.Fatalf("Fatal Error: %s\n", err)
+	}
+	if dialect.Comment, err = fh.ParseDelimiter(*comment); err != nil {
+		log.Fatalf("Fatal Error: %s\n", err)
+	}
+	if dialect.Encoding, err = fh.ParseEncoding(*encoding); err != nil {
+		log.Fatalf("Fatal Error: %s\n", err)
+	}
+
+	var reader fh.Readable
+	if name == stdin {
+		if *table == "" {
+			log.Fatalf("Table should be provided to read from Stdin")
+		}
+		reader, err = fh.NewReaderHandlerWithDialect(os.Stdin, name, dialect)
+	} else {
+		reader, err = fh.NewFileHandlerWithDialect(name, dialect)
+	}
+	if err != nil {
+		log.Fatalf("Fatal Error: %s\n", err)
+	}
+
+	if *table == "" {
+		*table = name
 	}
 
-	p, err := processor.NewProcessor(os.Args[1])
+	p, err := processor.NewProcessorFromReader(reader, processor.Options{
+		Table:      *table,
+		SampleRows: *sampleRows,
+		SchemaFile: *schema,
+		Key:        processor.ParseKey(*key),
+		OnConflict: *onConflict,
+		Source:     name,
+		Weight:     *weight,
+	}, cfg)
 	if err != nil {
 		log.Fatalf("Fatal Error: %s\n", err)
 	}
 
+	p.SetBatchSize(*batchSize)
+	if *shardKey == "" {
+		*shardKey = *key
+	}
+	if err := p.SetSharding(*shard, processor.ParseKey(*shardKey)); err != nil {
+		log.Fatalf("Fatal Error: %s\n", err)
+	}
+
+	if *resume {
+		if err := p.Resume(); err != nil {
+			log.Fatalf("Fatal Error: %s\n", err)
+		}
+	}
+
+	var delivered chan struct{}
+	if cfg.IPCSocket != "" {
+		conn, err := ipc.Dial(cfg.IPCSocket)
+		if err != nil {
+			log.Printf("Cannot connect to the integrator, which will poll the table. Error: %s\n", err)
+		} else {
+			defer conn.Close()
+			delivered = follow(conn)
+			p.SetIPC(conn)
+		}
+	}
+
 	if err := p.Migrate(); err != nil {
 		log.Fatalf("Fatal Error: %s\n", err)
 	}
+
+	if *wait && delivered != nil {
+		log.Println("Waiting for the integrator to send every row")
+		<-delivered
+	}
+}
+
+// follow Log the integrator's progress. The channel is closed once it has
+// sent every row, or has disconnected.
+func follow(conn *ipc.Conn) chan struct{} {
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		for {
+			m, err := conn.Receive()
+			if err != nil {
+				log.Println("The integrator has disconnected")
+				return
+			}
+			log.Printf("Integrator: %d rows processed, %d dead letters & %d pending\n", m.Processed, m.DeadLetters, m.Pending)
+			if m.Type == constants.IPCDone {
+				log.Println("Every row has been sent by the integrator")
+				return
+			}
+		}
+	}()
+	return delivered
 }
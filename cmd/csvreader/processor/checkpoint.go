@@ -0,0 +1,104 @@
+package processor
+
+import (
+	"log"
+	"sync"
+
+	"github.com/josesolana/csv-reader/cmd/csvreader/database"
+	fh "github.com/josesolana/csv-reader/cmd/csvreader/filehandler"
+	"github.com/josesolana/csv-reader/constants"
+)
+
+// checkpoint Tracks rows inserted by workers, which could be out of order,
+// to save the input position up to which every row is durable.
+type checkpoint struct {
+	db database.DB
+
+	mu sync.Mutex
+	// next Sequence for the next row read.
+	next int64
+	// acked Every row below it has been inserted.
+	acked int64
+	// positions Where rows read, but not acked yet, end.
+	positions map[int64]fh.Position
+	done      map[int64]bool
+	last      fh.Position
+	unsaved   int
+
+	saveMu sync.Mutex
+	saved  int64
+}
+
+func newCheckpoint(db database.DB) *checkpoint {
+	return &checkpoint{
+		db:        db,
+		positions: make(map[int64]fh.Position),
+		done:      make(map[int64]bool),
+		saved:     -1,
+	}
+}
+
+// start Rows before pos are already durable.
+func (c *checkpoint) start(pos fh.Position) {
+	c.mu.Lock()
+	c.last = pos
+	c.mu.Unlock()
+	c.saved = pos.Offset
+}
+
+// add Register a row read, which ends at pos. It returns its sequence.
+func (c *checkpoint) add(pos fh.Position) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seq := c.next
+	c.positions[seq] = pos
+	c.next++
+	return seq
+}
+
+// ack A row has been inserted. A checkpoint is saved every
+// constants.CheckpointRows contiguous rows.
+func (c *checkpoint) ack(seq int64) {
+	c.mu.Lock()
+	c.done[seq] = true
+	for c.done[c.acked] {
+		c.last = c.positions[c.acked]
+		delete(c.positions, c.acked)
+		delete(c.done, c.acked)
+		c.acked++
+		c.unsaved++
+	}
+	save := c.unsaved >= constants.CheckpointRows
+	if save {
+		c.unsaved = 0
+	}
+	pos := c.last
+	c.mu.Unlock()
+
+	if save {
+		c.save(pos)
+	}
+}
+
+// flush Save the last durable position.
+func (c *checkpoint) flush() {
+	c.mu.Lock()
+	pos := c.last
+	c.mu.Unlock()
+	c.save(pos)
+}
+
+// save Checkpoints never go backwards, although workers save them
+// concurrently.
+func (c *checkpoint) save(pos fh.Position) {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+	if pos.Offset <= c.saved {
+		return
+	}
+	if err := c.db.SaveCheckpoint(pos.Offset, pos.Line); err != nil {
+		log.Println("Cannot save a checkpoint. Error: ", err)
+		return
+	}
+	c.saved = pos.Offset
+}
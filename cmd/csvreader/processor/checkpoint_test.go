@@ -0,0 +1,59 @@
+package processor
+
+import (
+	"testing"
+
+	fh "github.com/josesolana/csv-reader/cmd/csvreader/filehandler"
+	"github.com/josesolana/csv-reader/cmd/csvreader/test/testutils"
+	"github.com/josesolana/csv-reader/constants"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CheckpointTest struct {
+	suite.Suite
+	db         *testutils.MockDB
+	checkpoint *checkpoint
+}
+
+func TestCheckpoint(t *testing.T) {
+	suite.Run(t, new(CheckpointTest))
+}
+
+func (ct *CheckpointTest) SetupTest() {
+	ct.db = testutils.NewMockDB()
+	ct.checkpoint = newCheckpoint(ct.db)
+}
+
+func (ct *CheckpointTest) TestOnlyContiguousRowsAreDurable() {
+	for i := 1; i <= 3; i++ {
+		ct.checkpoint.add(fh.Position{Offset: int64(i * 10), Line: i})
+	}
+	ct.checkpoint.ack(2)
+	ct.checkpoint.ack(0)
+
+	ct.db.On("SaveCheckpoint", int64(10), 1).Return(nil).Once()
+	ct.checkpoint.flush()
+
+	ct.checkpoint.ack(1)
+	ct.db.On("SaveCheckpoint", int64(30), 3).Return(nil).Once()
+	ct.checkpoint.flush()
+	ct.checkpoint.flush()
+
+	ct.db.AssertExpectations(ct.T())
+}
+
+func (ct *CheckpointTest) TestSavedEveryCheckpointRows() {
+	ct.db.On("SaveCheckpoint", int64(constants.CheckpointRows), constants.CheckpointRows).Return(nil).Once()
+	for i := 1; i <= constants.CheckpointRows+1; i++ {
+		seq := ct.checkpoint.add(fh.Position{Offset: int64(i), Line: i})
+		ct.checkpoint.ack(seq)
+	}
+	ct.db.AssertExpectations(ct.T())
+}
+
+func (ct *CheckpointTest) TestStartDoesNotGoBackwards() {
+	ct.checkpoint.start(fh.Position{Offset: 100, Line: 5})
+	ct.checkpoint.flush()
+	ct.db.AssertNotCalled(ct.T(), "SaveCheckpoint", int64(100), 5)
+}
@@ -1,80 +1,314 @@
 package processor
 
 import (
+	"database/sql"
+	"errors"
 	"io"
 	"log"
-	"math/rand"
 	"sync"
-	"time"
+	"sync/atomic"
 
 	"github.com/josesolana/csv-reader/cmd/csvreader/database"
 	fh "github.com/josesolana/csv-reader/cmd/csvreader/filehandler"
+	"github.com/josesolana/csv-reader/config"
 	"github.com/josesolana/csv-reader/constants"
+	"github.com/josesolana/csv-reader/ipc"
 )
 
 // Processor Read and save file into DB
 type Processor struct {
-	poolWorker          []chan []string
+	poolWorker          []chan task
 	runningWorkers, job *sync.WaitGroup
 	runCh               chan error
 	db                  database.DB
 	reader              fh.Readable
+	cfg                 *config.Config
+	checkpoint          *checkpoint
+	resumed             bool
+	batchSize           int
+	sharder             sharder
+	// inFlight Rows of every worker not inserted yet.
+	inFlight []int64
+	// columns File's header
+	columns []string
+	schema  database.Schema
+	// sample Rows already read to infer the schema.
+	sample []sampled
+	// table Table name, as created into the DB.
+	table string
+	// ipc Integrator told about the rows inserted. nil if there is none,
+	// or once a message has failed.
+	ipc   *ipc.Conn
+	ipcMu sync.Mutex
+	// inserted Rows inserted by this import.
+	inserted int64
+	// registered The import is into the registry.
+	registered bool
+}
+
+// task A row to be inserted, its sequence and line into the file.
+type task struct {
+	seq  int64
+	line int
+	row  []string
+}
+
+// sampled A row read and where it ends.
+type sampled struct {
+	row []string
+	pos fh.Position
+}
+
+// Options How a file is migrated into a table.
+type Options struct {
+	// Table Table to migrate into.
+	Table string
+	// SampleRows Rows read to infer the columns types.
+	SampleRows int
+	// SchemaFile JSON file overriding inferred types. Optional.
+	SchemaFile string
+	// Key Columns which identify a row. Every column if it's empty.
+	Key []string
+	// OnConflict What to do with a row whose key already exists:
+	// skip, overwrite or fail.
+	OnConflict string
+	// Source File imported, saved into the registry.
+	Source string
+	// Weight Batches the integrator daemon claims from the table every
+	// round. At least 1.
+	Weight int
 }
 
 // NewProcessor Factory pattern
-func NewProcessor(name string) (*Processor, error) {
+func NewProcessor(name string, cfg *config.Config) (*Processor, error) {
 	reader, err := fh.NewFileHandler(name)
 	if err != nil {
 		return nil, err
 	}
+	return NewProcessorFromReader(reader, Options{
+		Table:      name,
+		SampleRows: constants.SampleRows,
+		OnConflict: constants.ConflictSkip,
+		Source:     name,
+		Weight:     constants.ImportWeight,
+	}, cfg)
+}
 
+// NewProcessorFromReader Factory pattern. Rows are read from any Readable
+// (e.g. Stdin) and saved into opts.Table.
+// Columns types are inferred from the first opts.SampleRows rows, and then
+// overridden by opts.SchemaFile, if any.
+func NewProcessorFromReader(reader fh.Readable, opts Options, cfg *config.Config) (*Processor, error) {
 	row, err := reader.Read()
 	if err == io.EOF {
 		log.Println("File is empty")
+		reader.Close()
 		return nil, err
 	} else if err != nil {
 		log.Println("Cannot read a file line")
+		reader.Close()
 		return nil, err
 	}
 	log.Printf("Columns: %s\n", row)
-	return NewProcessorWithValues(reader, database.NewDB(name, row)), nil
+
+	if err := validateKey(row, opts.Key, opts.OnConflict); err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	sample := readSample(reader, opts.SampleRows)
+	rows := make([][]string, len(sample))
+	for i, s := range sample {
+		rows[i] = s.row
+	}
+	schema := database.InferSchema(row, rows)
+	if opts.SchemaFile != "" {
+		if schema, err = schema.Override(opts.SchemaFile); err != nil {
+			reader.Close()
+			return nil, err
+		}
+	}
+	log.Printf("Schema: %s\n", schema)
+
+	db := database.NewDBWithSchema(opts.Table, schema, opts.Key, opts.OnConflict, cfg)
+	if opts.Weight < 1 {
+		opts.Weight = constants.ImportWeight
+	}
+	if err := db.Register(opts.Source, opts.Weight); err != nil {
+		log.Println("Cannot register the import")
+		db.Close()
+		reader.Close()
+		return nil, err
+	}
+	p := NewProcessorWithValues(reader, db, cfg)
+	p.registered = true
+	p.columns = row
+	p.schema = schema
+	p.sample = sample
+	p.table = database.TableName(opts.Table)
+	return p, nil
+}
+
+// validateKey Key columns should be into the header.
+// Overwriting needs a key, every column cannot change.
+func validateKey(columns, key []string, onConflict string) error {
+	switch onConflict {
+	case constants.ConflictSkip, constants.ConflictFail:
+	case constants.ConflictOverwrite:
+		if len(key) == 0 {
+			return errors.New(constants.ErrConflictKey)
+		}
+	default:
+		log.Printf("Conflict policy unknown: %s\n", onConflict)
+		return errors.New(constants.ErrConflictUnknown)
+	}
+	for _, col := range key {
+		if indexOf(columns, col) < 0 {
+			log.Printf("Key column not found: %s\n", col)
+			return errors.New(constants.ErrColumnNotFound)
+		}
+	}
+	return nil
+}
+
+// readSample Read up to n rows, which are migrated before the rest.
+func readSample(reader fh.Readable, n int) []sampled {
+	sample := make([]sampled, 0, n)
+	for len(sample) < n {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Println("Skipped Line. Error: ", err)
+			continue
+		}
+		sample = append(sample, sampled{row: row, pos: reader.Position()})
+	}
+	return sample
 }
 
 // NewProcessorWithValues Factory pattern
-func NewProcessorWithValues(reader fh.Readable, db database.DB) *Processor {
+func NewProcessorWithValues(reader fh.Readable, db database.DB, cfg *config.Config) *Processor {
 	p := &Processor{
 		db:             db,
 		reader:         reader,
+		cfg:            cfg,
 		job:            new(sync.WaitGroup),
 		runningWorkers: new(sync.WaitGroup),
-		runCh:          make(chan error, constants.Workers),
+		runCh:          make(chan error, cfg.Workers),
+		checkpoint:     newCheckpoint(db),
+		batchSize:      constants.InsertBatchRows,
+		sharder:        &roundRobin{},
 	}
 	p.createPoolWorker()
 	return p
 }
 
+// SetBatchSize Rows inserted at once by each worker. 1 inserts row by row.
+// It should be called before Migrate.
+func (p *Processor) SetBatchSize(size int) {
+	if size < 1 {
+		size = 1
+	}
+	p.batchSize = size
+}
+
+// SetSharding How rows are balanced between workers: round-robin,
+// least-loaded or hash of the key columns.
+// It should be called before Migrate.
+func (p *Processor) SetSharding(strategy string, key []string) error {
+	s, err := newSharder(strategy, p.columns, key)
+	if err != nil {
+		return err
+	}
+	p.sharder = s
+	return nil
+}
+
+// SetIPC Tell the integrator connected by conn about the rows inserted:
+// the table at once, then every batch and the end of the file.
+// It should be called before Migrate.
+func (p *Processor) SetIPC(conn *ipc.Conn) {
+	p.ipc = conn
+	p.announce(ipc.Message{Type: constants.IPCTable})
+}
+
+// announce Send a message to the integrator, if any. The integrator polls
+// the table anyway, so after a failure the connection is closed and
+// nothing else is sent.
+func (p *Processor) announce(m ipc.Message) {
+	p.ipcMu.Lock()
+	defer p.ipcMu.Unlock()
+	if p.ipc == nil {
+		return
+	}
+	m.Table = p.table
+	if err := p.ipc.Send(m); err != nil {
+		log.Printf("Cannot tell the integrator about %s, which will poll the table. Error: %s\n", m.Type, err)
+		p.ipc.Close()
+		p.ipc = nil
+	}
+}
+
+// Resume Skip rows already inserted by a previous Migrate,
+// according to the last checkpoint saved.
+func (p *Processor) Resume() error {
+	offset, line, err := p.db.LoadCheckpoint()
+	if err == sql.ErrNoRows {
+		log.Println("There is no checkpoint. Starting from the beginning")
+		return nil
+	} else if err != nil {
+		log.Println("Cannot load the checkpoint")
+		return err
+	}
+
+	pos := fh.Position{Offset: offset, Line: line}
+	for len(p.sample) > 0 && p.sample[0].pos.Offset <= offset {
+		p.sample = p.sample[1:]
+	}
+	if err := p.reader.Resume(pos); err != nil {
+		log.Println("Cannot resume the file")
+		return err
+	}
+	log.Printf("Resuming after line %d\n", line)
+	p.checkpoint.start(pos)
+	p.resumed = true
+	return nil
+}
+
 // Migrate a file
 //
 // - Read from file
 //
 // - Save it into DB
-func (p *Processor) Migrate() error {
-	defer p.finish()
+func (p *Processor) Migrate() (err error) {
+	defer func() {
+		if errFinish := p.finish(err); err == nil {
+			err = errFinish
+		}
+		if err == nil {
+			p.announce(ipc.Message{Type: constants.IPCEOF, Rows: int(atomic.LoadInt64(&p.inserted))})
+		}
+	}()
 	var line []string
-	var err error
-	rand.Seed(time.Now().UTC().UnixNano())
+	var pos fh.Position
+	if !p.resumed {
+		// A previous checkpoint doesn't belong to this import.
+		p.checkpoint.save(fh.Position{})
+	}
 	for {
 		select {
 		case err := <-p.runCh:
 			return err
 		default:
-			line, err = p.reader.Read()
+			line, pos, err = p.next()
 			switch err {
 			case io.EOF:
 				log.Println("File has been complete")
 				return nil
 			case nil:
-				p.balanceLoad(line)
+				seq := p.checkpoint.add(pos)
+				p.balanceLoad(task{seq: seq, line: pos.Line, row: line})
 			default:
 				log.Println("Skipped Line. Error: ", err)
 			}
@@ -82,45 +316,124 @@ func (p *Processor) Migrate() error {
 	}
 }
 
+// next Sampled rows first, then the rest of the file.
+func (p *Processor) next() ([]string, fh.Position, error) {
+	if len(p.sample) > 0 {
+		s := p.sample[0]
+		p.sample = p.sample[1:]
+		return s.row, s.pos, nil
+	}
+	row, err := p.reader.Read()
+	return row, p.reader.Position(), err
+}
+
 // createPoolWorker Create a channel's slice.
-// There are go routines as workers set in constants.Workers.
+// There are go routines as workers set in Config.Workers.
 // Workers are a channel to a function which do the job.
 func (p *Processor) createPoolWorker() {
-	log.Printf("Starting %v Workers", constants.Workers)
-	p.runningWorkers.Add(constants.Workers)
+	log.Printf("Starting %v Workers", p.cfg.Workers)
+	p.runningWorkers.Add(p.cfg.Workers)
 
-	workers := make([]chan []string, constants.Workers)
+	workers := make([]chan task, p.cfg.Workers)
+	p.inFlight = make([]int64, p.cfg.Workers)
 	for i, _ := range workers {
-		w := make(chan []string, constants.Buff)
+		w := make(chan task, p.cfg.Buff)
 		workers[i] = w
-		go p.processRow(w, p.job, p.runningWorkers, p.runCh)
+		go p.processRow(w, &p.inFlight[i], p.job, p.runningWorkers, p.runCh)
 	}
 
 	p.poolWorker = workers
 }
 
-func (p *Processor) processRow(ch chan []string, job, runningWorkers *sync.WaitGroup, runCh chan error) {
+// processRow Insert rows in batches of p.batchSize. The last one is
+// inserted when the channel is closed. inFlight is decreased as rows are
+// done with.
+// After a failure, rows are discarded.
+func (p *Processor) processRow(ch chan task, inFlight *int64, job, runningWorkers *sync.WaitGroup, runCh chan error) {
 	ok := true
-	for row := range ch {
+	var batch []task
+	for t := range ch {
 		if ok {
-			err := p.db.Insert(row...)
-			if err != nil {
-				ok = false
-				runCh <- err
+			batch = append(batch, t)
+			if len(batch) >= p.batchSize {
+				ok = p.insert(batch, runCh)
+				atomic.AddInt64(inFlight, -int64(len(batch)))
+				batch = batch[:0]
 			}
+		} else {
+			atomic.AddInt64(inFlight, -1)
 		}
 		job.Done()
 	}
+	if ok && len(batch) > 0 {
+		p.insert(batch, runCh)
+		atomic.AddInt64(inFlight, -int64(len(batch)))
+	}
 	runningWorkers.Done()
 }
 
-func (p *Processor) finish() {
+// insert Rows which cannot be converted to the schema are skipped.
+func (p *Processor) insert(batch []task, runCh chan error) bool {
+	rows := make([][]interface{}, 0, len(batch))
+	for _, t := range batch {
+		row, err := p.schema.Convert(t.row)
+		if err != nil {
+			log.Printf("Skipped Line %d. Error: %s\n", t.line, err)
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	var err error
+	switch len(rows) {
+	case 0:
+	case 1:
+		err = p.db.Insert(rows[0]...)
+	default:
+		err = p.db.InsertBatch(rows)
+	}
+	if err != nil {
+		runCh <- err
+		return false
+	}
+
+	for _, t := range batch {
+		p.checkpoint.ack(t.seq)
+	}
+	if len(rows) > 0 {
+		atomic.AddInt64(&p.inserted, int64(len(rows)))
+		p.announce(ipc.Message{Type: constants.IPCRows, From: batch[0].line, To: batch[len(batch)-1].line, Rows: len(rows)})
+	}
+	return true
+}
+
+// finish Wait for workers and close everything. The import is registered
+// as imported, or as failed after migrateErr or a worker's failure.
+// It returns a worker's failure, if any.
+func (p *Processor) finish(migrateErr error) error {
 	p.job.Wait()
 	for _, ch := range p.poolWorker {
 		close(ch)
 	}
 	p.runningWorkers.Wait()
 	log.Printf("Every worker's channels has been closed")
+	p.checkpoint.flush()
+
+	var err error
+	select {
+	case err = <-p.runCh:
+	default:
+	}
+
+	if p.registered {
+		status := constants.ImportImported
+		if err != nil || migrateErr != nil {
+			status = constants.ImportFailed
+		}
+		if err := p.db.SetImportStatus(status); err != nil {
+			log.Println("Cannot update the import's status. Error: ", err)
+		}
+	}
 
 	if err := p.db.Close(); err != nil {
 		log.Println("Cannot close DB. Error: ", err)
@@ -128,13 +441,12 @@ func (p *Processor) finish() {
 	if err := p.reader.Close(); err != nil {
 		log.Println("Cannot close Reader. Error: ", err)
 	}
+	return err
 }
 
-func (p *Processor) balanceLoad(line []string) {
-	// Random string value in Bytes used for a "random" balance
-	randStr := line[rand.Intn(len(line))]
-	randChar := randStr[rand.Intn(len(randStr))]
-	w := randChar % constants.Workers
+func (p *Processor) balanceLoad(t task) {
+	w := p.sharder.Shard(t.row, p.inFlight)
 	p.job.Add(1)
-	p.poolWorker[w] <- line
+	atomic.AddInt64(&p.inFlight[w], 1)
+	p.poolWorker[w] <- t
 }
@@ -1,9 +1,17 @@
 package processor
 
 import (
+	"errors"
+	"net"
+	"sync"
 	"testing"
 
+	"github.com/josesolana/csv-reader/cmd/csvreader/database"
+	fh "github.com/josesolana/csv-reader/cmd/csvreader/filehandler"
 	"github.com/josesolana/csv-reader/cmd/csvreader/test/testutils"
+	"github.com/josesolana/csv-reader/config"
+	"github.com/josesolana/csv-reader/constants"
+	"github.com/josesolana/csv-reader/ipc"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -24,9 +32,115 @@ func (pt *ProcessorTest) SetupTest() {
 	pt.db = testutils.NewMockDB()
 	pt.mockRow = testutils.NewMockRows()
 	pt.mockReader = testutils.NewMockReadable()
-	pt.processor = NewProcessorWithValues(pt.mockReader, pt.db)
+	pt.processor = NewProcessorWithValues(pt.mockReader, pt.db, config.Default())
 }
 
 func (pt *ProcessorTest) Migrate() {
 
 }
+
+func (pt *ProcessorTest) TestProcessRowBatches() {
+	pt.processor.SetBatchSize(2)
+	rows := [][]string{{"1", "Fons"}, {"2", "Mal"}, {"3", "Eve"}}
+	pt.db.On("InsertBatch", [][]interface{}{{"1", "Fons"}, {"2", "Mal"}}).Return(nil).Once()
+	pt.db.On("Insert", []interface{}{"3", "Eve"}).Return(nil).Once()
+
+	pt.processRows(rows)
+	pt.db.AssertExpectations(pt.T())
+}
+
+func (pt *ProcessorTest) TestProcessRowStopsAfterFailure() {
+	pt.processor.SetBatchSize(2)
+	rows := [][]string{{"1", "Fons"}, {"2", "Mal"}, {"3", "Eve"}}
+	pt.db.On("InsertBatch", [][]interface{}{{"1", "Fons"}, {"2", "Mal"}}).Return(errors.New("COPY failed")).Once()
+
+	runCh := pt.processRows(rows)
+	pt.EqualError(<-runCh, "COPY failed")
+	pt.db.AssertExpectations(pt.T())
+	pt.db.AssertNotCalled(pt.T(), "Insert", []interface{}{"3", "Eve"})
+}
+
+func (pt *ProcessorTest) TestProcessRowSkipsConversionFailures() {
+	pt.processor.SetBatchSize(3)
+	pt.processor.schema = database.Schema{{Name: "id", Type: constants.TypeInteger}, {Name: "name", Type: constants.TypeText}}
+	rows := [][]string{{"1", "Fons"}, {"two", "Mal"}, {"3", "Eve"}}
+	pt.db.On("InsertBatch", [][]interface{}{{"1", "Fons"}, {"3", "Eve"}}).Return(nil).Once()
+
+	pt.processRows(rows)
+	pt.db.AssertExpectations(pt.T())
+}
+
+func (pt *ProcessorTest) TestImportStatus() {
+	pt.processor.registered = true
+	pt.db.On("SetImportStatus", constants.ImportFailed).Return(nil).Once()
+	pt.db.On("SaveCheckpoint", int64(0), 0).Return(nil)
+	pt.db.On("Close").Return(nil)
+	pt.mockReader.On("Close").Return(nil)
+
+	pt.Nil(pt.processor.finish(errors.New("cannot read")))
+	pt.db.AssertExpectations(pt.T())
+}
+
+func (pt *ProcessorTest) TestAnnounceRows() {
+	a, b := net.Pipe()
+	integrator := ipc.NewConn(b)
+	defer integrator.Close()
+	pt.processor.table = "customers"
+	pt.db.On("InsertBatch", [][]interface{}{{"1", "Fons"}, {"3", "Eve"}}).Return(nil).Once()
+
+	go func() {
+		pt.processor.SetIPC(ipc.NewConn(a))
+		batch := []task{
+			{seq: pt.processor.checkpoint.add(fh.Position{}), line: 2, row: []string{"1", "Fons"}},
+			{seq: pt.processor.checkpoint.add(fh.Position{}), line: 4, row: []string{"3", "Eve"}},
+		}
+		pt.processor.insert(batch, make(chan error, 1))
+	}()
+
+	m, err := integrator.Receive()
+	pt.Nil(err)
+	pt.Equal(ipc.Message{Type: constants.IPCTable, Table: "customers"}, m)
+	m, err = integrator.Receive()
+	pt.Nil(err)
+	pt.Equal(ipc.Message{Type: constants.IPCRows, Table: "customers", From: 2, To: 4, Rows: 2}, m)
+	pt.db.AssertExpectations(pt.T())
+}
+
+// TestAnnounceFailure The connection is dropped after a failed message.
+func (pt *ProcessorTest) TestAnnounceFailure() {
+	a, b := net.Pipe()
+	b.Close()
+	pt.processor.table = "customers"
+	pt.processor.SetIPC(ipc.NewConn(a))
+	pt.Nil(pt.processor.ipc)
+	_, err := a.Write([]byte{0})
+	pt.Error(err)
+	pt.processor.announce(ipc.Message{Type: constants.IPCEOF})
+}
+
+func (pt *ProcessorTest) TestValidateKey() {
+	columns := []string{"id", "first_name", "email"}
+	pt.Nil(validateKey(columns, nil, constants.ConflictSkip))
+	pt.Nil(validateKey(columns, []string{"id", "email"}, constants.ConflictOverwrite))
+	pt.Nil(validateKey(columns, []string{"id"}, constants.ConflictFail))
+	pt.EqualError(validateKey(columns, nil, constants.ConflictOverwrite), constants.ErrConflictKey)
+	pt.EqualError(validateKey(columns, []string{"phone"}, constants.ConflictSkip), constants.ErrColumnNotFound)
+	pt.EqualError(validateKey(columns, []string{"id"}, "replace"), constants.ErrConflictUnknown)
+}
+
+// processRows Run a worker over rows until every one has been processed.
+func (pt *ProcessorTest) processRows(rows [][]string) chan error {
+	ch := make(chan task, len(rows))
+	job, running := new(sync.WaitGroup), new(sync.WaitGroup)
+	runCh := make(chan error, 1)
+	for _, row := range rows {
+		job.Add(1)
+		ch <- task{seq: pt.processor.checkpoint.add(fh.Position{}), row: row}
+	}
+	close(ch)
+
+	running.Add(1)
+	pt.processor.processRow(ch, new(int64), job, running, runCh)
+	running.Wait()
+	return runCh
+}
@@ -0,0 +1,111 @@
+package processor
+
+import (
+	"errors"
+	"hash/fnv"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"github.com/josesolana/csv-reader/constants"
+)
+
+// sharder Chooses which worker inserts a row. inFlight holds the rows
+// every worker hasn't inserted yet: queued into its channel or waiting
+// into its batch. It's updated atomically by the workers.
+// It's only called from Migrate, so it doesn't need to be thread safe.
+type sharder interface {
+	Shard(row []string, inFlight []int64) int
+}
+
+// newSharder Sharder for a strategy: round-robin, least-loaded or hash.
+// hash needs the key columns, whose values are hashed, among the columns.
+func newSharder(strategy string, columns, key []string) (sharder, error) {
+	switch strategy {
+	case constants.ShardRoundRobin:
+		return &roundRobin{}, nil
+	case constants.ShardLeastLoaded:
+		return &leastLoaded{}, nil
+	case constants.ShardHash:
+		return newKeyHash(columns, key)
+	}
+	log.Printf("Sharding strategy unknown: %s\n", strategy)
+	return nil, errors.New(constants.ErrShardingUnknown)
+}
+
+// ParseKey Split a composite key given as comma separated columns.
+func ParseKey(s string) []string {
+	var key []string
+	for _, col := range strings.Split(s, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			key = append(key, col)
+		}
+	}
+	return key
+}
+
+// roundRobin Every worker in turn.
+type roundRobin struct {
+	next int
+}
+
+func (r *roundRobin) Shard(row []string, workers []int64) int {
+	w := r.next % len(workers)
+	r.next = w + 1
+	return w
+}
+
+// leastLoaded Worker with fewer rows in flight. Ties go to the first one.
+type leastLoaded struct{}
+
+func (l *leastLoaded) Shard(row []string, inFlight []int64) int {
+	best, min := 0, atomic.LoadInt64(&inFlight[0])
+	for i := range inFlight {
+		if n := atomic.LoadInt64(&inFlight[i]); n < min {
+			best, min = i, n
+		}
+	}
+	return best
+}
+
+// keyHash Rows with the same key go to the same worker, so they are
+// inserted in file order.
+type keyHash struct {
+	key []int
+}
+
+func newKeyHash(columns, key []string) (*keyHash, error) {
+	if len(key) == 0 {
+		return nil, errors.New(constants.ErrShardingKey)
+	}
+	k := &keyHash{key: make([]int, len(key))}
+	for i, col := range key {
+		k.key[i] = indexOf(columns, col)
+		if k.key[i] < 0 {
+			log.Printf("Key column not found: %s\n", col)
+			return nil, errors.New(constants.ErrColumnNotFound)
+		}
+	}
+	return k, nil
+}
+
+func (k *keyHash) Shard(row []string, workers []int64) int {
+	h := fnv.New32a()
+	for _, i := range k.key {
+		if i < len(row) {
+			h.Write([]byte(row[i]))
+		}
+		// Separator, so ("ab", "c") and ("a", "bc") differ.
+		h.Write([]byte{0})
+	}
+	return int(h.Sum32() % uint32(len(workers)))
+}
+
+func indexOf(columns []string, col string) int {
+	for i, c := range columns {
+		if c == col {
+			return i
+		}
+	}
+	return -1
+}
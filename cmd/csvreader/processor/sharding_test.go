@@ -0,0 +1,94 @@
+package processor
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/josesolana/csv-reader/config"
+	"github.com/josesolana/csv-reader/constants"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ShardingTest struct {
+	suite.Suite
+	columns []string
+	workers []int64
+}
+
+func TestSharding(t *testing.T) {
+	suite.Run(t, new(ShardingTest))
+}
+
+func (st *ShardingTest) SetupTest() {
+	st.columns = []string{"id", "first_name", "email"}
+	st.workers = make([]int64, 4)
+}
+
+func (st *ShardingTest) TestRoundRobin() {
+	s, err := newSharder(constants.ShardRoundRobin, st.columns, nil)
+	st.Nil(err)
+	for i := 0; i < 9; i++ {
+		st.Equal(i%len(st.workers), s.Shard([]string{"1", "", ""}, st.workers))
+	}
+}
+
+func (st *ShardingTest) TestLeastLoaded() {
+	s, err := newSharder(constants.ShardLeastLoaded, st.columns, nil)
+	st.Nil(err)
+	st.workers = []int64{1, 1, 0, 1}
+	st.Equal(2, s.Shard(nil, st.workers))
+}
+
+// TestLeastLoadedBatch Rows waiting into a worker's batch are load.
+func (st *ShardingTest) TestLeastLoadedBatch() {
+	p := &Processor{
+		cfg:            config.Default(),
+		batchSize:      10,
+		runningWorkers: new(sync.WaitGroup),
+		job:            new(sync.WaitGroup),
+		runCh:          make(chan error, 1),
+	}
+	p.cfg.Workers = 2
+	p.sharder, _ = newSharder(constants.ShardLeastLoaded, st.columns, nil)
+	p.createPoolWorker()
+
+	for i := 0; i < 4; i++ {
+		p.balanceLoad(task{row: []string{"1", "", ""}})
+	}
+	// Rows are taken from the channels into the batches.
+	p.job.Wait()
+	st.Equal(int64(2), atomic.LoadInt64(&p.inFlight[0]))
+	st.Equal(int64(2), atomic.LoadInt64(&p.inFlight[1]))
+}
+
+func (st *ShardingTest) TestHashSameKeySameWorker() {
+	s, err := newSharder(constants.ShardHash, st.columns, []string{"id"})
+	st.Nil(err)
+	w := s.Shard([]string{"42", "Fons", "old@example.com"}, st.workers)
+	for i := 0; i < 10; i++ {
+		st.Equal(w, s.Shard([]string{"42", "Fons", "new@example.com"}, st.workers))
+	}
+}
+
+func (st *ShardingTest) TestHashEmptyField() {
+	s, err := newSharder(constants.ShardHash, st.columns, []string{"first_name", "email"})
+	st.Nil(err)
+	w := s.Shard([]string{"1", "", ""}, st.workers)
+	st.True(w >= 0 && w < len(st.workers))
+}
+
+func (st *ShardingTest) TestHashErrors() {
+	_, err := newSharder(constants.ShardHash, st.columns, nil)
+	st.EqualError(err, constants.ErrShardingKey)
+	_, err = newSharder(constants.ShardHash, st.columns, []string{"phone"})
+	st.EqualError(err, constants.ErrColumnNotFound)
+	_, err = newSharder("random", st.columns, nil)
+	st.EqualError(err, constants.ErrShardingUnknown)
+}
+
+func (st *ShardingTest) TestParseKey() {
+	st.Equal([]string{"id", "email"}, ParseKey(" id, email ,"))
+	st.Nil(ParseKey(""))
+}
@@ -0,0 +1,77 @@
+package test
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"testing"
+
+	_ "github.com/lib/pq"
+
+	"github.com/josesolana/csv-reader/cmd/csvreader/database"
+	"github.com/josesolana/csv-reader/config"
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+const benchTable = "bench_insert"
+
+var (
+	benchOnce sync.Once
+	benchDb   database.DB
+)
+
+// BenchmarkInsert One prepared INSERT per row.
+func BenchmarkInsert(b *testing.B) {
+	db := setupBench(b)
+	rows := benchRows(b.N)
+	b.ResetTimer()
+
+	for _, row := range rows {
+		if err := db.Insert(row...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkInsertBatch COPY into a staging table per batch.
+func BenchmarkInsertBatch(b *testing.B) {
+	db := setupBench(b)
+	rows := benchRows(b.N)
+	b.ResetTimer()
+
+	for i := 0; i < len(rows); i += c.InsertBatchRows {
+		end := i + c.InsertBatchRows
+		if end > len(rows) {
+			end = len(rows)
+		}
+		if err := db.InsertBatch(rows[i:end]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// setupBench Both benchmarks share the table, which is emptied before each run.
+func setupBench(b *testing.B) database.DB {
+	benchOnce.Do(func() {
+		benchDb = database.NewDB(benchTable, []string{"id", "first_name", "last_name", "email"}, config.Default())
+	})
+	conn := database.ConnectDb(config.Default().Database)
+	defer conn.Close()
+	if _, err := conn.Exec("TRUNCATE " + benchTable); err != nil {
+		log.Fatalf("Cannot truncate %s table. Error: %s\n", benchTable, err)
+	}
+	return benchDb
+}
+
+func benchRows(n int) [][]interface{} {
+	rows := make([][]interface{}, n)
+	for i := range rows {
+		rows[i] = []interface{}{
+			fmt.Sprint(i),
+			fmt.Sprintf("first_name_%d", i),
+			fmt.Sprintf("last_name_%d", i),
+			fmt.Sprintf("user%d@example.com", i),
+		}
+	}
+	return rows
+}
@@ -0,0 +1,116 @@
+package test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	fh "github.com/josesolana/csv-reader/cmd/csvreader/filehandler"
+	c "github.com/josesolana/csv-reader/constants"
+	"github.com/stretchr/testify/suite"
+)
+
+type DialectTest struct {
+	suite.Suite
+}
+
+func TestDialectController(t *testing.T) {
+	suite.Run(t, new(DialectTest))
+}
+
+func (dt *DialectTest) TestParseDelimiter() {
+	for s, r := range map[string]rune{",": ',', "semicolon": ';', "tab": '\t', `\t`: '\t', "|": '|', "": 0} {
+		got, err := fh.ParseDelimiter(s)
+		dt.Nil(err)
+		dt.Equal(r, got)
+	}
+	_, err := fh.ParseDelimiter(";;")
+	dt.Contains(err.Error(), c.ErrDelimiterInvalid)
+}
+
+func (dt *DialectTest) TestValidate() {
+	dt.Nil(fh.DefaultDialect.Validate())
+	dt.EqualError(fh.Dialect{Comma: '"'}.Validate(), c.ErrDialectInvalid)
+	dt.EqualError(fh.Dialect{Comma: '#', Comment: '#'}.Validate(), c.ErrDialectInvalid)
+}
+
+func (dt *DialectTest) TestSemicolonWithComments() {
+	d := fh.DefaultDialect
+	d.Comma = ';'
+	d.Comment = '#'
+	d.TrimLeadingSpace = true
+
+	nfh, err := fh.NewFileHandlerWithDialect(c.FileNameMockSemicolon+c.AcceptedExt, d)
+	dt.Nil(err)
+	defer nfh.Close()
+
+	dt.read(nfh,
+		[]string{"id", "first_name", "last_name"},
+		[]string{"1", "Hadleigh", "Mahedy"},
+		[]string{"2", "Fons", "Gouthier"},
+	)
+}
+
+func (dt *DialectTest) TestAutoDetect() {
+	d := fh.DefaultDialect
+	d.AutoDetect = true
+	d.TrimLeadingSpace = true
+
+	nfh, err := fh.NewFileHandlerWithDialect(c.FileNameMockSemicolon+c.AcceptedExt, d)
+	dt.Nil(err)
+	defer nfh.Close()
+
+	dt.read(nfh,
+		[]string{"id", "first_name", "last_name"},
+		[]string{"1", "Hadleigh", "Mahedy"},
+		[]string{"2", "Fons", "Gouthier"},
+	)
+}
+
+func (dt *DialectTest) TestSniff() {
+	d := fh.DefaultDialect.Sniff([]byte("1\tFons\tGouthier\n2\tMal\tWattisham\n3\tEve"))
+	dt.Equal('\t', d.Comma)
+	dt.Equal(rune(0), d.Comment)
+	dt.False(d.Header)
+
+	d = fh.DefaultDialect.Sniff([]byte("id|name\n1|Fons\n2|Mal\n"))
+	dt.Equal('|', d.Comma)
+	dt.True(d.Header)
+}
+
+func (dt *DialectTest) TestWithoutHeader() {
+	d := fh.DefaultDialect
+	d.Header = false
+
+	nfh, err := fh.NewReaderHandlerWithDialect(strings.NewReader("1,Fons\n2,Mal\n"), "-", d)
+	dt.Nil(err)
+	defer nfh.Close()
+
+	dt.read(nfh,
+		[]string{"column_1", "column_2"},
+		[]string{"1", "Fons"},
+		[]string{"2", "Mal"},
+	)
+}
+
+func (dt *DialectTest) TestWithoutHeaderEmpty() {
+	d := fh.DefaultDialect
+	d.Header = false
+
+	nfh, err := fh.NewReaderHandlerWithDialect(strings.NewReader(""), "-", d)
+	dt.Nil(err)
+	defer nfh.Close()
+
+	_, err = nfh.Read()
+	dt.Equal(io.EOF, err)
+}
+
+func (dt *DialectTest) read(r fh.Readable, lines ...[]string) {
+	for _, expected := range lines {
+		line, err := r.Read()
+		dt.Nil(err)
+		dt.Equal(expected, line)
+	}
+	_, err := r.Read()
+	dt.Equal(io.EOF, err)
+}
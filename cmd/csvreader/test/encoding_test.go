@@ -0,0 +1,119 @@
+package test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"unicode/utf16"
+
+	fh "github.com/josesolana/csv-reader/cmd/csvreader/filehandler"
+	c "github.com/josesolana/csv-reader/constants"
+	"github.com/stretchr/testify/suite"
+)
+
+type EncodingTest struct {
+	suite.Suite
+}
+
+func TestEncodingController(t *testing.T) {
+	suite.Run(t, new(EncodingTest))
+}
+
+func (et *EncodingTest) TestParseEncoding() {
+	for name, expected := range map[string]string{
+		"UTF-8":        c.EncodingUTF8,
+		"latin1":       c.EncodingISO88591,
+		"cp1252":       c.EncodingWindows1252,
+		"utf-16le":     c.EncodingUTF16LE,
+		"":             c.EncodingAuto,
+		"auto":         c.EncodingAuto,
+		"Windows-1252": c.EncodingWindows1252,
+	} {
+		got, err := fh.ParseEncoding(name)
+		et.Nil(err)
+		et.Equal(expected, got)
+	}
+	_, err := fh.ParseEncoding("ebcdic")
+	et.EqualError(err, c.ErrEncodingUnknown)
+}
+
+func (et *EncodingTest) TestUTF8BOM() {
+	et.read([]byte("\xef\xbb\xbfid,name\n1,José\n"), c.EncodingUTF8, []string{"id", "name"}, []string{"1", "José"})
+}
+
+func (et *EncodingTest) TestUTF16LEBOM() {
+	et.read(utf16LE("\ufeffid,name\r\n1,Zoë 😀\r\n"), c.EncodingUTF8, []string{"id", "name"}, []string{"1", "Zoë 😀"})
+}
+
+func (et *EncodingTest) TestUTF16LEAutoDetect() {
+	et.read(utf16LE("id,name\n1,Zoë\n"), c.EncodingAuto, []string{"id", "name"}, []string{"1", "Zoë"})
+}
+
+func (et *EncodingTest) TestWindows1252() {
+	et.read([]byte("id,name\n1,Caf\xe9 \x80\n"), c.EncodingWindows1252, []string{"id", "name"}, []string{"1", "Café €"})
+}
+
+func (et *EncodingTest) TestWindows1252AutoDetect() {
+	et.read([]byte("id,name\n1,Caf\xe9\n"), c.EncodingAuto, []string{"id", "name"}, []string{"1", "Café"})
+}
+
+func (et *EncodingTest) TestISO88591() {
+	et.read([]byte("id,name\n1,Caf\xe9 \x80\n"), c.EncodingISO88591, []string{"id", "name"}, []string{"1", "Café \u0080"})
+}
+
+func (et *EncodingTest) TestInvalidSequencesReplaced() {
+	et.read([]byte("id,name\n1,Caf\xe9\n"), c.EncodingUTF8, []string{"id", "name"}, []string{"1", "Caf\ufffd"})
+}
+
+func (et *EncodingTest) read(content []byte, encoding string, lines ...[]string) {
+	d := fh.DefaultDialect
+	d.Encoding = encoding
+
+	nfh, err := fh.NewReaderHandlerWithDialect(bytes.NewReader(content), "-", d)
+	et.Nil(err)
+	defer nfh.Close()
+
+	for _, expected := range lines {
+		line, err := nfh.Read()
+		et.Nil(err)
+		et.Equal(expected, line)
+	}
+	_, err = nfh.Read()
+	et.Equal(io.EOF, err)
+}
+
+func utf16LE(s string) []byte {
+	var b bytes.Buffer
+	for _, u := range utf16.Encode([]rune(s)) {
+		b.WriteByte(byte(u))
+		b.WriteByte(byte(u >> 8))
+	}
+	return b.Bytes()
+}
+
+// TestUTF8Buffers Valid UTF-8 is passed through across the reader's
+// buffers, with multibyte characters split between them.
+func (et *EncodingTest) TestUTF8Buffers() {
+	var content bytes.Buffer
+	content.WriteString("id,name\n")
+	for i := 0; i < 2000; i++ {
+		content.WriteString("1,Zoë 😀 Café\n")
+	}
+	content.WriteString("2,Caf\xe9\n")
+
+	nfh, err := fh.NewReaderHandler(&content, "-")
+	et.Nil(err)
+	_, err = nfh.Read()
+	et.Nil(err)
+	for i := 0; i < 2000; i++ {
+		line, err := nfh.Read()
+		et.Nil(err)
+		et.Equal([]string{"1", "Zoë 😀 Café"}, line)
+	}
+	line, err := nfh.Read()
+	et.Nil(err)
+	et.Equal([]string{"2", "Caf�"}, line)
+	et.Equal(2002, nfh.Position().Line)
+	_, err = nfh.Read()
+	et.Equal(io.EOF, err)
+}
@@ -2,6 +2,9 @@ package test
 
 import (
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	fh "github.com/josesolana/csv-reader/cmd/csvreader/filehandler"
@@ -29,6 +32,13 @@ func (fht *FileHandlerTest) TestGetFilePathWrongExtensionFile() {
 	fht.Empty(nfh)
 }
 
+func (fht *FileHandlerTest) TestGetFilePathAbsolute() {
+	name := filepath.Join(os.TempDir(), c.FileNameMock+c.AcceptedExt)
+	nfh, err := fh.GetFilePath(name)
+	fht.Nil(err)
+	fht.Equal(name, nfh)
+}
+
 func (fht *FileHandlerTest) TestNewFileHandlerNonExistentFile() {
 	nfh, err := fh.NewFileHandler("NonExistent" + c.AcceptedExt)
 	fht.Contains(err.Error(), "no such file or directory")
@@ -60,3 +70,91 @@ func (fht *FileHandlerTest) TestNewFileHandlerRead() {
 	fht.NotNil(err)
 	fht.Error(io.EOF, err)
 }
+
+func (fht *FileHandlerTest) TestGetFilePathCompressedSuccess() {
+	for _, ext := range []string{c.GzipExt, c.Bzip2Ext, c.ZstdExt} {
+		nfh, err := fh.GetFilePath(c.FileNameMock + c.AcceptedExt + ext)
+		fht.Nil(err)
+		fht.NotEmpty(nfh)
+	}
+}
+
+func (fht *FileHandlerTest) TestGetFilePathCompressedWrongExtensionFile() {
+	nfh, err := fh.GetFilePath("TEST.txt" + c.GzipExt)
+	fht.EqualError(err, c.ErrExtensionFile)
+	fht.Empty(nfh)
+}
+
+func (fht *FileHandlerTest) TestNewFileHandlerReadGzip() {
+	fht.readCompressed(c.FileNameMock + c.AcceptedExt + c.GzipExt)
+}
+
+func (fht *FileHandlerTest) TestNewFileHandlerReadBzip2() {
+	fht.readCompressed(c.FileNameMock + c.AcceptedExt + c.Bzip2Ext)
+}
+
+func (fht *FileHandlerTest) TestNewFileHandlerReadGzipByMagicBytes() {
+	fht.readCompressed(c.FileNameMockGzipMagic + c.AcceptedExt)
+}
+
+func (fht *FileHandlerTest) TestNewFileHandlerReadZstd() {
+	fht.readCompressed(c.FileNameMock + c.AcceptedExt + c.ZstdExt)
+}
+
+// TestNewReaderHandlerBzip2Magic A plain file starting by "BZh" isn't
+// bzip2 unless a block size follows.
+func (fht *FileHandlerTest) TestNewReaderHandlerBzip2Magic() {
+	nfh, err := fh.NewReaderHandler(strings.NewReader("BZh,name\n1,Fons\n"), "-")
+	fht.Nil(err)
+	defer nfh.Close()
+	line, err := nfh.Read()
+	fht.Nil(err)
+	fht.Equal([]string{"BZh", "name"}, line)
+}
+
+func (fht *FileHandlerTest) readCompressed(name string) {
+	lines, err := lineCounter(c.FileNameMock + c.AcceptedExt)
+	fht.Nil(err)
+
+	nfh, err := fh.NewFileHandler(name)
+	fht.Nil(err)
+	fht.NotNil(nfh)
+	defer nfh.Close()
+
+	for i := 0; i <= lines; i++ {
+		line, err := nfh.Read()
+		fht.NotEmpty(line)
+		fht.Nil(err)
+	}
+	_, err = nfh.Read()
+	fht.Equal(io.EOF, err)
+}
+
+func (fht *FileHandlerTest) TestNewReaderHandlerRead() {
+	nfh, err := fh.NewReaderHandler(strings.NewReader("id,name\n1,Fons\n"), "-")
+	fht.Nil(err)
+	defer nfh.Close()
+
+	line, err := nfh.Read()
+	fht.Nil(err)
+	fht.Equal([]string{"id", "name"}, line)
+	line, err = nfh.Read()
+	fht.Nil(err)
+	fht.Equal([]string{"1", "Fons"}, line)
+	_, err = nfh.Read()
+	fht.Equal(io.EOF, err)
+}
+
+func (fht *FileHandlerTest) TestNewReaderHandlerReadGzip() {
+	file, err := os.Open(c.FileNameMock + c.AcceptedExt + c.GzipExt)
+	fht.Nil(err)
+	defer file.Close()
+
+	nfh, err := fh.NewReaderHandler(file, "-")
+	fht.Nil(err)
+	defer nfh.Close()
+
+	line, err := nfh.Read()
+	fht.Nil(err)
+	fht.Equal([]string{"id", "first_name", "last_name", "email", "phone"}, line)
+}
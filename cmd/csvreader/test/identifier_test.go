@@ -0,0 +1,67 @@
+package test
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/josesolana/csv-reader/cmd/csvreader/database"
+	c "github.com/josesolana/csv-reader/constants"
+	"github.com/stretchr/testify/suite"
+)
+
+type IdentifierTest struct {
+	suite.Suite
+}
+
+func TestIdentifierController(t *testing.T) {
+	suite.Run(t, new(IdentifierTest))
+}
+
+func (it *IdentifierTest) TestIdentifier() {
+	cases := map[string]string{
+		"id":               "id",
+		"First Name":       "first_name",
+		"firstName":        "first_name",
+		"HTTPCode":         "http_code",
+		" E-mail ":         "e_mail",
+		"select":           "select",
+		"a;DROP TABLE x--": "a_drop_table_x",
+		`"quoted"`:         "quoted",
+		"Año":              "año",
+		"--":               "",
+	}
+	for name, expected := range cases {
+		it.Equal(expected, database.Identifier(name), name)
+	}
+}
+
+func (it *IdentifierTest) TestIdentifierLength() {
+	id := database.Identifier(strings.Repeat("ñ", 40))
+	it.True(len(id) <= c.MaxIdentifierLen)
+	it.True(utf8.ValidString(id))
+}
+
+func (it *IdentifierTest) TestColumnNames() {
+	names := database.ColumnNames("customers", []string{"Email", "email", "E-mail", "", "email_2"})
+	it.Equal([]string{
+		"customers_email",
+		"customers_email_2",
+		"customers_e_mail",
+		"customers_column_4",
+		"customers_email_2_2",
+	}, names)
+}
+
+func (it *IdentifierTest) TestColumnNamesLength() {
+	long := strings.Repeat("x", 70)
+	names := database.ColumnNames("customers", []string{long, long})
+	it.Len(names[0], c.MaxIdentifierLen)
+	it.Len(names[1], c.MaxIdentifierLen)
+	it.NotEqual(names[0], names[1])
+	it.True(strings.HasSuffix(names[1], "_2"))
+}
+
+func (it *IdentifierTest) TestTableName() {
+	it.Equal("my_customers", database.TableName("/tmp/My Customers.csv.gz"))
+}
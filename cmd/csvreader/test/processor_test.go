@@ -2,8 +2,11 @@ package test
 
 import (
 	"io"
+	"io/ioutil"
 	"log"
+	"os"
 	"path"
+	"path/filepath"
 	"testing"
 
 	_ "github.com/lib/pq"
@@ -12,6 +15,7 @@ import (
 
 	"github.com/josesolana/csv-reader/cmd/csvreader/database"
 	p "github.com/josesolana/csv-reader/cmd/csvreader/processor"
+	"github.com/josesolana/csv-reader/config"
 	c "github.com/josesolana/csv-reader/constants"
 	"github.com/stretchr/testify/suite"
 )
@@ -26,7 +30,7 @@ func TestProcessorController(t *testing.T) {
 }
 
 func (pt *ProcessorTest) SetupSuite() {
-	pt.db = database.ConnectDb()
+	pt.db = database.ConnectDb(config.Default().Database)
 }
 
 func (pt *ProcessorTest) TearDownSuite() {
@@ -39,7 +43,7 @@ func (pt *ProcessorTest) TestCSVBlank() {
 	name := c.FileNameMockEmpty + c.AcceptedExt
 	defer pt.tearDown(c.FileNameMockEmpty)
 
-	proc, err := p.NewProcessor(name)
+	proc, err := p.NewProcessor(name, config.Default())
 	pt.NotNil(err)
 	pt.EqualError(err, io.EOF.Error())
 	pt.Nil(proc)
@@ -49,7 +53,7 @@ func (pt *ProcessorTest) TestCSVErrorReading() {
 	name := c.FileNameMockErrorReading + c.AcceptedExt
 	defer pt.tearDown(c.FileNameMockErrorReading)
 
-	proc, err := p.NewProcessor(name)
+	proc, err := p.NewProcessor(name, config.Default())
 	pt.NotNil(err)
 	pt.NotEqual(err.Error(), io.EOF.Error())
 	pt.Nil(proc)
@@ -58,11 +62,12 @@ func (pt *ProcessorTest) TestCSVErrorReading() {
 func (pt *ProcessorTest) TestCSVSuccess() {
 	name := c.FileNameMock + c.AcceptedExt
 	defer pt.tearDown(c.FileNameMock)
+	defer pt.tearDownCheckpoint(c.FileNameMock)
 
 	fileLines, err := lineCounter(name)
 	pt.Nil(err)
 
-	proc, err := p.NewProcessor(name)
+	proc, err := p.NewProcessor(name, config.Default())
 	pt.Nil(err)
 
 	err = proc.Migrate()
@@ -77,6 +82,28 @@ func (pt *ProcessorTest) TestCSVSuccess() {
 
 }
 
+// TestDuplicatesWithEmptyCells A row with an empty typed cell, NULL into
+// the table, is a duplicate when the file is imported again.
+func (pt *ProcessorTest) TestDuplicatesWithEmptyCells() {
+	dir, err := ioutil.TempDir("", "csvreader")
+	pt.Require().Nil(err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "empty_cells_mock"+c.AcceptedExt)
+	pt.Require().Nil(ioutil.WriteFile(name, []byte("id,age,born\n1,30,1990-01-02\n2,,\n"), 0644))
+	defer pt.tearDown("empty_cells_mock")
+	defer pt.tearDownCheckpoint("empty_cells_mock")
+
+	for i := 0; i < 2; i++ {
+		proc, err := p.NewProcessor(name, config.Default())
+		pt.Require().Nil(err)
+		pt.Nil(proc.Migrate())
+	}
+
+	var count int
+	pt.Nil(pt.db.QueryRow("SELECT COUNT(*) FROM empty_cells_mock").Scan(&count))
+	pt.Equal(2, count)
+}
+
 func (pt *ProcessorTest) tearDown(name string) {
 	name = path.Base(name)
 	_, err := pt.db.Exec("DROP TABLE IF EXISTS " + name)
@@ -84,3 +111,12 @@ func (pt *ProcessorTest) tearDown(name string) {
 		log.Fatalf("Teardown fail dropping %s table. Error: %s\n ", name, err)
 	}
 }
+
+// tearDownCheckpoint Checkpoints table only exists if an import has started.
+func (pt *ProcessorTest) tearDownCheckpoint(name string) {
+	name = path.Base(name)
+	_, err := pt.db.Exec("DELETE FROM "+c.CheckpointTable+" WHERE table_name = $1", name)
+	if err != nil {
+		log.Fatalf("Teardown fail deleting %s checkpoint. Error: %s\n ", name, err)
+	}
+}
@@ -0,0 +1,147 @@
+package test
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	fh "github.com/josesolana/csv-reader/cmd/csvreader/filehandler"
+	c "github.com/josesolana/csv-reader/constants"
+	"github.com/stretchr/testify/suite"
+)
+
+type ResumeTest struct {
+	suite.Suite
+}
+
+func TestResumeController(t *testing.T) {
+	suite.Run(t, new(ResumeTest))
+}
+
+func (rt *ResumeTest) TestResumeSeek() {
+	rt.resume(c.FileNameMock + c.AcceptedExt)
+}
+
+func (rt *ResumeTest) TestResumeSkip() {
+	rt.resume(c.FileNameMock + c.AcceptedExt + c.GzipExt)
+}
+
+func (rt *ResumeTest) TestResumeSeekAfterInvalidSequence() {
+	content := "\xef\xbb\xbfid,name\n1,Caf\xe9\n2,Fons\n3,Mal\n"
+	pos := rt.positionAfter(strings.NewReader(content), 2)
+	rt.Equal(2, pos.Line-1)
+
+	nfh, err := fh.NewReaderHandler(strings.NewReader(content), "-")
+	rt.Nil(err)
+	_, err = nfh.Read()
+	rt.Nil(err)
+
+	rt.Nil(nfh.Resume(pos))
+	line, err := nfh.Read()
+	rt.Nil(err)
+	rt.Equal([]string{"3", "Mal"}, line)
+	rt.Equal(4, nfh.Position().Line)
+	_, err = nfh.Read()
+	rt.Equal(io.EOF, err)
+}
+
+func (rt *ResumeTest) TestResumeWithoutHeader() {
+	d := fh.DefaultDialect
+	d.Header = false
+	content := "1,Fons\n2,Mal\n3,Eve\n"
+
+	nfh, err := fh.NewReaderHandlerWithDialect(strings.NewReader(content), "-", d)
+	rt.Nil(err)
+	_, err = nfh.Read()
+	rt.Nil(err)
+	_, err = nfh.Read()
+	rt.Nil(err)
+	pos := nfh.Position()
+
+	nfh, err = fh.NewReaderHandlerWithDialect(strings.NewReader(content), "-", d)
+	rt.Nil(err)
+	_, err = nfh.Read()
+	rt.Nil(err)
+	rt.Nil(nfh.Resume(pos))
+	line, err := nfh.Read()
+	rt.Nil(err)
+	rt.Equal([]string{"2", "Mal"}, line)
+}
+
+func (rt *ResumeTest) TestResumeWithoutHeaderFromStart() {
+	d := fh.DefaultDialect
+	d.Header = false
+	content := "1,Fons\n2,Mal\n"
+
+	nfh, err := fh.NewReaderHandlerWithDialect(strings.NewReader(content), "-", d)
+	rt.Nil(err)
+	_, err = nfh.Read()
+	rt.Nil(err)
+	rt.Nil(nfh.Resume(fh.Position{}))
+	line, err := nfh.Read()
+	rt.Nil(err)
+	rt.Equal([]string{"1", "Fons"}, line)
+}
+
+// TestResumePipe A pipe is an *os.File, but rows are skipped.
+func (rt *ResumeTest) TestResumePipe() {
+	content := "id,name\n1,Fons\n2,Mal\n3,Eve\n"
+	pos := rt.positionAfter(strings.NewReader(content), 2)
+
+	r, w, err := os.Pipe()
+	rt.Nil(err)
+	defer r.Close()
+	go func() {
+		w.WriteString(content)
+		w.Close()
+	}()
+
+	nfh, err := fh.NewReaderHandler(r, "-")
+	rt.Nil(err)
+	_, err = nfh.Read()
+	rt.Nil(err)
+	rt.Nil(nfh.Resume(pos))
+	line, err := nfh.Read()
+	rt.Nil(err)
+	rt.Equal([]string{"3", "Eve"}, line)
+}
+
+func (rt *ResumeTest) resume(name string) {
+	file, err := os.Open(name)
+	rt.Nil(err)
+	pos := rt.positionAfter(file, 2)
+	file.Close()
+	rt.Equal(3, pos.Line)
+
+	nfh, err := fh.NewFileHandler(name)
+	rt.Nil(err)
+	defer nfh.Close()
+	_, err = nfh.Read()
+	rt.Nil(err)
+
+	rt.Nil(nfh.Resume(pos))
+	rt.Equal(pos, nfh.Position())
+	line, err := nfh.Read()
+	rt.Nil(err)
+	rt.Equal("3", line[0])
+	line, err = nfh.Read()
+	rt.Nil(err)
+	rt.Equal("4", line[0])
+	rt.Equal(5, nfh.Position().Line)
+	_, err = nfh.Read()
+	rt.Equal(io.EOF, err)
+}
+
+// positionAfter Position after reading the header and n rows.
+func (rt *ResumeTest) positionAfter(r io.Reader, n int) fh.Position {
+	nfh, err := fh.NewReaderHandler(r, "-")
+	rt.Nil(err)
+	defer nfh.Close()
+
+	for i := 0; i <= n; i++ {
+		_, err := nfh.Read()
+		rt.Nil(err)
+	}
+	return nfh.Position()
+}
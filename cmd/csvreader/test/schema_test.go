@@ -0,0 +1,96 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/josesolana/csv-reader/cmd/csvreader/database"
+	c "github.com/josesolana/csv-reader/constants"
+	"github.com/stretchr/testify/suite"
+)
+
+type SchemaTest struct {
+	suite.Suite
+	columns []string
+	rows    [][]string
+}
+
+func TestSchemaController(t *testing.T) {
+	suite.Run(t, new(SchemaTest))
+}
+
+func (st *SchemaTest) SetupTest() {
+	st.columns = []string{"id", "zip", "price", "active", "born", "updated", "phone", "address", "big"}
+	st.rows = [][]string{
+		{"1", "00123", "10.5", "yes", "1990-01-02", "2019-03-25T10:00:00Z", "840 586 9744", "Long Street 1", "5000000000"},
+		{"2", "10100", "3", "N", "2001/12/31", "2019-03-25 10:00:00", "", "", "1"},
+	}
+}
+
+func (st *SchemaTest) TestInferSchema() {
+	s := database.InferSchema(st.columns, st.rows)
+	st.Equal(database.Schema{
+		{Name: "id", Type: c.TypeInteger, Nullable: true},
+		{Name: "zip", Type: c.TypeText},
+		{Name: "price", Type: c.TypeNumeric, Nullable: true},
+		{Name: "active", Type: c.TypeBoolean, Nullable: true},
+		{Name: "born", Type: c.TypeDate, Nullable: true},
+		{Name: "updated", Type: c.TypeTimestamp, Nullable: true},
+		{Name: "phone", Type: c.TypeText},
+		{Name: "address", Type: c.TypeText},
+		{Name: "big", Type: c.TypeBigInt, Nullable: true},
+	}, s)
+}
+
+// TestInferSchemaNullable Typed columns are nullable, even without empty
+// values into the sample: later rows could have some.
+func (st *SchemaTest) TestInferSchemaNullable() {
+	s := database.InferSchema([]string{"id", "age", "name"}, [][]string{{"1", "30", ""}, {"2", "", "Fons"}})
+	st.Equal(database.Schema{
+		{Name: "id", Type: c.TypeInteger, Nullable: true},
+		{Name: "age", Type: c.TypeInteger, Nullable: true},
+		{Name: "name", Type: c.TypeText},
+	}, s)
+
+	row, err := s.Convert([]string{"", "31", ""})
+	st.Nil(err)
+	st.Equal([]interface{}{nil, "31", ""}, row)
+}
+
+func (st *SchemaTest) TestInferSchemaWithoutRows() {
+	s := database.InferSchema([]string{"id"}, nil)
+	st.Equal(database.TextSchema([]string{"id"}), s)
+}
+
+func (st *SchemaTest) TestOverride() {
+	s, err := database.InferSchema(st.columns, st.rows).Override(c.FileNameSchemaMock)
+	st.Nil(err)
+	st.Equal(database.Column{Name: "id", Type: c.TypeBigInt, Nullable: true}, s[0])
+	st.Equal(database.Column{Name: "phone", Type: c.TypeText, Nullable: true}, s[6])
+}
+
+func (st *SchemaTest) TestOverrideUnknownColumn() {
+	_, err := database.TextSchema([]string{"id"}).Override(c.FileNameSchemaMock)
+	st.EqualError(err, c.ErrColumnNotFound)
+}
+
+func (st *SchemaTest) TestConvert() {
+	s := database.InferSchema(st.columns, st.rows)
+	row, err := s.Convert(st.rows[1])
+	st.Nil(err)
+	st.Equal([]interface{}{"2", "10100", "3", "false", "2001-12-31", "2019-03-25 10:00:00", "", "", "1"}, row)
+
+	s = database.InferSchema([]string{"id", "age"}, [][]string{{"1", "30"}, {"2", ""}})
+	row, err = s.Convert([]string{"3", " "})
+	st.Nil(err)
+	st.Equal([]interface{}{"3", nil}, row)
+}
+
+func (st *SchemaTest) TestConvertFailure() {
+	s := database.InferSchema(st.columns, st.rows)
+	_, err := s.Convert([]string{"x", "1", "1", "y", "1990-01-02", "2019-03-25 10:00:00", "", "", "1"})
+	st.Contains(err.Error(), c.ErrConversion)
+
+	s[0].Nullable = false
+	_, err = s.Convert([]string{"", "1", "1", "y", "1990-01-02", "2019-03-25 10:00:00", "", "", "1"})
+	st.Contains(err.Error(), c.ErrConversion)
+}
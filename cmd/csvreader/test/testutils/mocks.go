@@ -1,6 +1,7 @@
 package testutils
 
 import (
+	fh "github.com/josesolana/csv-reader/cmd/csvreader/filehandler"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -34,11 +35,33 @@ func (d *MockDB) Read(limit, offset int64) (*MockRows, error) {
 	return mockRows, args.Error(1)
 }
 
-func (d *MockDB) Insert(row ...string) error {
+func (d *MockDB) Insert(row ...interface{}) error {
 	args := d.Called(row)
 	return args.Error(0)
 }
 
+func (d *MockDB) InsertBatch(rows [][]interface{}) error {
+	args := d.Called(rows)
+	return args.Error(0)
+}
+
+func (d *MockDB) SaveCheckpoint(offset int64, line int) error {
+	return d.Called(offset, line).Error(0)
+}
+
+func (d *MockDB) LoadCheckpoint() (int64, int, error) {
+	args := d.Called()
+	return args.Get(0).(int64), args.Int(1), args.Error(2)
+}
+
+func (d *MockDB) Register(source string, weight int) error {
+	return d.Called(source, weight).Error(0)
+}
+
+func (d *MockDB) SetImportStatus(status string) error {
+	return d.Called(status).Error(0)
+}
+
 func (d *MockDB) Close() error {
 	return d.Called().Error(0)
 }
@@ -48,6 +71,14 @@ func (d *MockReadable) Read() ([]string, error) {
 	return args.Get(0).([]string), args.Error(1)
 }
 
+func (d *MockReadable) Position() fh.Position {
+	return d.Called().Get(0).(fh.Position)
+}
+
+func (d *MockReadable) Resume(pos fh.Position) error {
+	return d.Called(pos).Error(0)
+}
+
 func (d *MockReadable) Close() error {
 	return d.Called().Error(0)
 }
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	c "github.com/josesolana/csv-reader/constants"
+
+	"github.com/stretchr/testify/suite"
+)
+
+const testPrefix = "CSVREADER_TEST"
+
+type ConfigTest struct {
+	suite.Suite
+	dir string
+}
+
+func TestConfig(t *testing.T) {
+	suite.Run(t, new(ConfigTest))
+}
+
+func (ct *ConfigTest) SetupTest() {
+	dir, err := ioutil.TempDir("", "config")
+	ct.Nil(err)
+	ct.dir = dir
+}
+
+func (ct *ConfigTest) TearDownTest() {
+	os.RemoveAll(ct.dir)
+	for _, f := range fields {
+		os.Unsetenv(f.env(testPrefix))
+	}
+	os.Unsetenv(testPrefix + "_CONFIG")
+}
+
+func (ct *ConfigTest) write(name, content string) string {
+	path := filepath.Join(ct.dir, name)
+	ct.Nil(ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func (ct *ConfigTest) load(args ...string) (*Config, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	l := NewLoader(testPrefix, Integrator, fs)
+	ct.Nil(fs.Parse(args))
+	return l.Load()
+}
+
+// TestCSVReader csvreader only reads its own settings & the database's.
+func (ct *ConfigTest) TestCSVReader() {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	l := NewLoader(testPrefix, CSVReader, fs)
+	ct.Error(fs.Parse([]string{"--crm-url", "https://crm.example.com"}))
+	ct.Nil(fs.Parse([]string{"--workers", "3", "--database-name", "crm"}))
+
+	// Ignored, though they are invalid for the integrator.
+	os.Setenv(testPrefix+"_CRM_URL", "crm")
+	os.Setenv(testPrefix+"_LEASE_TIME", "1s")
+	cfg, err := l.Load()
+	ct.Nil(err)
+	ct.Equal(3, cfg.Workers)
+	ct.Equal("crm", cfg.Database.Name)
+	ct.Equal(Default().CRMUrl, cfg.CRMUrl)
+
+	os.Setenv(testPrefix+"_CONFIG", ct.write("config.json", `{"crm_url": "https://crm.example.com"}`))
+	_, err = l.Load()
+	ct.EqualError(err, c.ErrConfigKey)
+}
+
+func (ct *ConfigTest) TestDefault() {
+	cfg, err := ct.load()
+	ct.Nil(err)
+	ct.Equal(Default(), cfg)
+}
+
+func (ct *ConfigTest) TestYAML() {
+	path := ct.write("config.yaml", `
+# Tuning
+workers: 8
+timeout: "5s" # per request
+crm_url: https://crm.example.com/customers#list
+database:
+  host: db.example.com
+  port: 6543
+  password: 'p#ss word'
+`)
+	cfg, err := ct.load("--config", path)
+	ct.Nil(err)
+	ct.Equal(8, cfg.Workers)
+	ct.Equal(5*time.Second, cfg.TimeOut)
+	ct.Equal("https://crm.example.com/customers#list", cfg.CRMUrl)
+	ct.Equal("db.example.com", cfg.Database.Host)
+	ct.Equal(6543, cfg.Database.Port)
+	ct.Equal("p#ss word", cfg.Database.Password)
+}
+
+func (ct *ConfigTest) TestJSON() {
+	path := ct.write("config.json", `{"buff": 3, "database": {"sslmode": "require", "name": "crm"}}`)
+	os.Setenv(testPrefix+"_CONFIG", path)
+	cfg, err := ct.load()
+	ct.Nil(err)
+	ct.Equal(3, cfg.Buff)
+	ct.Equal("require", cfg.Database.SSLMode)
+	ct.Equal("crm", cfg.Database.Name)
+}
+
+func (ct *ConfigTest) TestPrecedence() {
+	path := ct.write("config.json", `{"workers": 2, "buff": 3, "total_retry": 4}`)
+	os.Setenv(testPrefix+"_BUFF", "30")
+	os.Setenv(testPrefix+"_TOTAL_RETRY", "40")
+	cfg, err := ct.load("--config", path, "--total-retry", "400")
+	ct.Nil(err)
+	ct.Equal(2, cfg.Workers)
+	ct.Equal(30, cfg.Buff)
+	ct.Equal(400, cfg.TotalRetry)
+}
+
+func (ct *ConfigTest) TestRetryBackoff() {
+	cfg, err := ct.load("--retry-min", "1s", "--retry-max", "1m", "--retry-factor", "1.5", "--retry-jitter=false")
+	ct.Nil(err)
+	ct.Equal(time.Second, cfg.RetryMin)
+	ct.Equal(time.Minute, cfg.RetryMax)
+	ct.Equal(1.5, cfg.RetryFactor)
+	ct.False(cfg.RetryJitter)
+
+	_, err = ct.load("--retry-min", "2m", "--retry-max", "1m")
+	ct.Error(err)
+	_, err = ct.load("--retry-factor", "0.5")
+	ct.Error(err)
+}
+
+func (ct *ConfigTest) TestSecrets() {
+	path := ct.write("token", "s3cret\n")
+	os.Setenv(testPrefix+"_CRM_AUTH_TOKEN", "file:"+path)
+	os.Setenv(testPrefix+"_SECRET_PASSWORD", "p4ss")
+	defer os.Unsetenv(testPrefix + "_SECRET_PASSWORD")
+
+	cfg, err := ct.load("--crm-auth", "bearer", "--database-password", "env:"+testPrefix+"_SECRET_PASSWORD")
+	ct.Nil(err)
+	ct.Equal("s3cret", cfg.CRMAuthToken)
+	ct.Equal("p4ss", cfg.Database.Password)
+
+	_, err = ct.load("--crm-auth-password", "env:"+testPrefix+"_MISSING")
+	ct.EqualError(err, c.ErrSecret)
+	_, err = ct.load("--crm-auth", "basic")
+	ct.Error(err)
+	_, err = ct.load("--crm-auth", "kerberos")
+	ct.Error(err)
+}
+
+func (ct *ConfigTest) TestErrors() {
+	_, err := ct.load("--workers", "0")
+	ct.EqualError(err, c.ErrConfigInvalid+": workers should be at least 1")
+	_, err = ct.load("--timeout", "3")
+	ct.EqualError(err, c.ErrConfigInvalid+`: timeout "3"`)
+	_, err = ct.load("--lease-time", "1s")
+	ct.Error(err)
+	_, err = ct.load("--idempotency-key", "uuid")
+	ct.Error(err)
+	_, err = ct.load("--crm-batch-size", "2", "--crm-path", "/customers/{{.ID}}")
+	ct.Error(err)
+	_, err = ct.load("--crm-batch-size", "2", "--crm-path", "/customers/bulk")
+	ct.Nil(err)
+	_, err = ct.load("--database-sslmode", "maybe")
+	ct.Error(err)
+	_, err = ct.load("--config", ct.write("config.json", `{"worker": 2}`))
+	ct.EqualError(err, c.ErrConfigKey)
+	_, err = ct.load("--config", ct.write("config.toml", `workers = 2`))
+	ct.EqualError(err, c.ErrConfigFormat)
+	_, err = ct.load("--config", ct.write("config.yml", "workers:\n  - 2\n"))
+	ct.Error(err)
+}
+
+func (ct *ConfigTest) TestDSN() {
+	d := Database{Host: "localhost", Port: 5432, User: "postgres", Password: "it's", Name: "customers", SSLMode: "disable"}
+	ct.Equal(`host='localhost' user='postgres' password='it\'s' dbname='customers' sslmode='disable' port=5432`, d.DSN())
+
+	d.URL = "postgres://user:pass@db:5432/customers?sslmode=require"
+	ct.Equal(d.URL, d.DSN())
+	ct.Nil(d.Validate())
+	d.URL = "mysql://db"
+	ct.Error(d.Validate())
+}
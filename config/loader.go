@@ -0,0 +1,316 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// field A setting, by its key into a file, e.g. database.host.
+// Its environment variable is PREFIX_DATABASE_HOST and its flag
+// --database-host.
+type field struct {
+	key   string
+	usage string
+	ptr   func(cfg *Config) interface{}
+}
+
+var fields = []field{
+	{"workers", "Number of go routines concurrently", func(cfg *Config) interface{} { return &cfg.Workers }},
+	{"buff", "Workers's buffer channel", func(cfg *Config) interface{} { return &cfg.Buff }},
+	{"batch_size_row", "Rows read at once by the integrator", func(cfg *Config) interface{} { return &cfg.BatchSizeRow }},
+	{"total_retry", "Number of time before skip a row", func(cfg *Config) interface{} { return &cfg.TotalRetry }},
+	{"retry_min", "Delay before a row is sent again after its first failure, e.g. 10s", func(cfg *Config) interface{} { return &cfg.RetryMin }},
+	{"retry_max", "Max delay before a row is sent again, e.g. 5m", func(cfg *Config) interface{} { return &cfg.RetryMax }},
+	{"retry_factor", "The delay is multiplied by it after every failure", func(cfg *Config) interface{} { return &cfg.RetryFactor }},
+	{"retry_jitter", "Randomize the delays, so failed rows don't come back at once", func(cfg *Config) interface{} { return &cfg.RetryJitter }},
+	{"lease_time", "How long rows claimed by an integrator are its own, e.g. 5m", func(cfg *Config) interface{} { return &cfg.LeaseTime }},
+	{"crm_url", "CRM Json Api's base Url", func(cfg *Config) interface{} { return &cfg.CRMUrl }},
+	{"crm_path", "Path template added to crm_url, e.g. /customers/{{.ID}}", func(cfg *Config) interface{} { return &cfg.CRMPath }},
+	{"crm_method", "Http method, e.g. POST or PUT", func(cfg *Config) interface{} { return &cfg.CRMMethod }},
+	{"crm_headers", "Headers sent to the CRM, e.g. \"X-Tenant: acme; X-Source: csv\"", func(cfg *Config) interface{} { return &cfg.CRMHeaders }},
+	{"crm_rate", "Max requests per second to the CRM, lowered while it answers 429. 0 for no limit", func(cfg *Config) interface{} { return &cfg.CRMRate }},
+	{"crm_burst", "Requests made at once after an idle time", func(cfg *Config) interface{} { return &cfg.CRMBurst }},
+	{"crm_max_in_flight", "Max requests to the CRM at once. 0 for no limit", func(cfg *Config) interface{} { return &cfg.CRMMaxInFlight }},
+	{"breaker_failures", "CRM outages in a row which open the circuit breaker. 0 disables it", func(cfg *Config) interface{} { return &cfg.BreakerFailures }},
+	{"breaker_cooldown", "Time the circuit breaker stays open before a probe, e.g. 30s", func(cfg *Config) interface{} { return &cfg.BreakerCooldown }},
+	{"metrics_addr", "Address which serves metrics at /debug/vars, e.g. localhost:9090", func(cfg *Config) interface{} { return &cfg.MetricsAddr }},
+	{"crm_auth", "CRM authentication: bearer, api_key, basic or oauth2. Empty for none", func(cfg *Config) interface{} { return &cfg.CRMAuth }},
+	{"crm_auth_token", "Bearer token or API key. Secret", func(cfg *Config) interface{} { return &cfg.CRMAuthToken }},
+	{"crm_auth_header", "Header which carries the API key", func(cfg *Config) interface{} { return &cfg.CRMAuthHeader }},
+	{"crm_auth_user", "Basic authentication user", func(cfg *Config) interface{} { return &cfg.CRMAuthUser }},
+	{"crm_auth_password", "Basic authentication password. Secret", func(cfg *Config) interface{} { return &cfg.CRMAuthPassword }},
+	{"crm_oauth_token_url", "OAuth2 token endpoint", func(cfg *Config) interface{} { return &cfg.CRMOAuthTokenURL }},
+	{"crm_oauth_client_id", "OAuth2 client id", func(cfg *Config) interface{} { return &cfg.CRMOAuthClientID }},
+	{"crm_oauth_client_secret", "OAuth2 client secret. Secret", func(cfg *Config) interface{} { return &cfg.CRMOAuthClientSecret }},
+	{"crm_oauth_scope", "OAuth2 scopes, separated by spaces", func(cfg *Config) interface{} { return &cfg.CRMOAuthScope }},
+	{"crm_mapping", "JSON file which maps columns to the payload fields", func(cfg *Config) interface{} { return &cfg.CRMMapping }},
+	{"crm_batch_size", "Rows sent at once as a JSON array. 1 sends a row per request", func(cfg *Config) interface{} { return &cfg.CRMBatchSize }},
+	{"crm_batch_results", "Path to the results array into a batch response, e.g. data.results", func(cfg *Config) interface{} { return &cfg.CRMBatchResults }},
+	{"crm_batch_id", "Path to the row id or idempotency key into every result. Empty to match them by position", func(cfg *Config) interface{} { return &cfg.CRMBatchID }},
+	{"crm_batch_status", "Path to the Http status into every result. Empty to use the response status", func(cfg *Config) interface{} { return &cfg.CRMBatchStatus }},
+	{"idempotency_key", "How a row's idempotency key is made: id (table, row id & version) or hash (table & row content)", func(cfg *Config) interface{} { return &cfg.IdempotencyKey }},
+	{"idempotency_header", "Header which carries the idempotency key. Empty to not send it", func(cfg *Config) interface{} { return &cfg.IdempotencyHeader }},
+	{"idempotency_field", "Body field which carries the idempotency key. Empty to not send it", func(cfg *Config) interface{} { return &cfg.IdempotencyField }},
+	{"ipc_socket", "Unix socket by which csvreader tells the integrator about new rows, e.g. /tmp/csvreader.sock", func(cfg *Config) interface{} { return &cfg.IPCSocket }},
+	{"chaos_fail_rate", "Percent of CRM requests which fail on purpose. Only for tests", func(cfg *Config) interface{} { return &cfg.ChaosFailRate }},
+	{"timeout", "Http requests timeout, e.g. 3s", func(cfg *Config) interface{} { return &cfg.TimeOut }},
+	{"database.url", "Full connection URL. It wins over the other database settings", func(cfg *Config) interface{} { return &cfg.Database.URL }},
+	{"database.host", "Database host", func(cfg *Config) interface{} { return &cfg.Database.Host }},
+	{"database.port", "Database port", func(cfg *Config) interface{} { return &cfg.Database.Port }},
+	{"database.user", "Database user", func(cfg *Config) interface{} { return &cfg.Database.User }},
+	{"database.password", "Database password. Secret", func(cfg *Config) interface{} { return &cfg.Database.Password }},
+	{"database.name", "Database name", func(cfg *Config) interface{} { return &cfg.Database.Name }},
+	{"database.sslmode", "disable, allow, prefer, require, verify-ca or verify-full", func(cfg *Config) interface{} { return &cfg.Database.SSLMode }},
+}
+
+// Settings Fields a binary reads & how they are validated. Database
+// fields are shared by both binaries.
+type Settings struct {
+	fields   []field
+	validate func(cfg *Config) error
+}
+
+// CSVReader Settings of csvreader: workers, its integrator & the database.
+var CSVReader = &Settings{
+	fields:   withKeys("workers", "buff", "ipc_socket"),
+	validate: (*Config).validateShared,
+}
+
+// Integrator Settings of the CRM Integrator: every one.
+var Integrator = &Settings{
+	fields:   fields,
+	validate: (*Config).Validate,
+}
+
+// withKeys Fields of keys, followed by the database fields.
+func withKeys(keys ...string) []field {
+	var fs []field
+	for _, key := range keys {
+		f, ok := fieldByKey(fields, key)
+		if !ok {
+			panic("config: unknown key " + key)
+		}
+		fs = append(fs, f)
+	}
+	for _, f := range fields {
+		if strings.HasPrefix(f.key, "database.") {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+// secrets Settings which can also be read from a file, e.g.
+// file:/run/secrets/token, or from another environment variable, e.g.
+// env:CRM_TOKEN.
+var secrets = []string{"crm_auth_token", "crm_auth_password", "crm_oauth_client_secret", "database.password"}
+
+func (f field) set(cfg *Config, value string) error {
+	var err error
+	switch p := f.ptr(cfg).(type) {
+	case *int:
+		*p, err = strconv.Atoi(strings.TrimSpace(value))
+	case *float64:
+		*p, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+	case *bool:
+		*p, err = strconv.ParseBool(strings.TrimSpace(value))
+	case *time.Duration:
+		*p, err = time.ParseDuration(strings.TrimSpace(value))
+	case *string:
+		*p = value
+	}
+	if err != nil {
+		return invalid(fmt.Sprintf("%s %q", f.key, value))
+	}
+	return nil
+}
+
+func (f field) env(prefix string) string {
+	return prefix + "_" + strings.ToUpper(strings.Replace(f.key, ".", "_", -1))
+}
+
+func (f field) flag() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(f.key)
+}
+
+// Loader Loads a Config with this precedence, from lowest to highest:
+// defaults, a YAML or JSON file, PREFIX_* environment variables & flags.
+type Loader struct {
+	prefix   string
+	settings *Settings
+	fs       *flag.FlagSet
+	file     *string
+	flags    map[string]*string
+}
+
+// NewLoader Register --config and a flag for every setting of s into fs.
+// fs should be parsed before Load is called.
+func NewLoader(prefix string, s *Settings, fs *flag.FlagSet) *Loader {
+	l := &Loader{
+		prefix:   prefix,
+		settings: s,
+		fs:       fs,
+		file:     fs.String("config", "", "YAML or JSON configuration file. Also "+prefix+"_CONFIG"),
+		flags:    make(map[string]*string, len(s.fields)),
+	}
+	for _, f := range s.fields {
+		l.flags[f.flag()] = fs.String(f.flag(), "", f.usage+". Also "+f.env(prefix))
+	}
+	return l
+}
+
+// Load Build and validate the Config.
+func (l *Loader) Load() (*Config, error) {
+	cfg := Default()
+
+	file := os.Getenv(l.prefix + "_CONFIG")
+	if *l.file != "" {
+		file = *l.file
+	}
+	if file != "" {
+		if err := loadFile(cfg, file, l.settings.fields); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range l.settings.fields {
+		if v, ok := os.LookupEnv(f.env(l.prefix)); ok {
+			if err := f.set(cfg, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var err error
+	l.fs.Visit(func(fl *flag.Flag) {
+		for _, f := range l.settings.fields {
+			if err == nil && fl.Name == f.flag() {
+				err = f.set(cfg, *l.flags[fl.Name])
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := readSecrets(cfg, l.settings.fields); err != nil {
+		return nil, err
+	}
+	if err := l.settings.validate(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func readSecrets(cfg *Config, fields []field) error {
+	for _, key := range secrets {
+		f, ok := fieldByKey(fields, key)
+		if !ok {
+			continue
+		}
+		p := f.ptr(cfg).(*string)
+		switch {
+		case strings.HasPrefix(*p, c.SecretFile):
+			path := strings.TrimPrefix(*p, c.SecretFile)
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				log.Printf("Cannot read the %s file: %s\n", key, path)
+				return errors.New(c.ErrSecret)
+			}
+			*p = strings.TrimRight(string(data), "\r\n")
+		case strings.HasPrefix(*p, c.SecretEnv):
+			name := strings.TrimPrefix(*p, c.SecretEnv)
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				log.Printf("Environment variable of %s not set: %s\n", key, name)
+				return errors.New(c.ErrSecret)
+			}
+			*p = v
+		}
+	}
+	return nil
+}
+
+// loadFile The format is chosen by the file extension. Keys which aren't
+// among fields are an error.
+func loadFile(cfg *Config, path string, fields []field) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("Cannot read config file: %s\n", path)
+		return err
+	}
+
+	var values map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		values, err = parseJSON(data)
+	case ".yaml", ".yml":
+		values, err = parseYAML(data)
+	default:
+		return errors.New(c.ErrConfigFormat)
+	}
+	if err != nil {
+		log.Printf("Cannot decode config file: %s\n", path)
+		return err
+	}
+
+	for key, v := range values {
+		f, ok := fieldByKey(fields, key)
+		if !ok {
+			log.Printf("Config key unknown: %s\n", key)
+			return errors.New(c.ErrConfigKey)
+		}
+		if err := f.set(cfg, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fieldByKey(fields []field, key string) (field, bool) {
+	for _, f := range fields {
+		if f.key == key {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// parseJSON Nested objects are flattened: {"database": {"host": "x"}}
+// is database.host.
+func parseJSON(data []byte) (map[string]string, error) {
+	var obj map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&obj); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	flatten("", obj, values)
+	return values, nil
+}
+
+func flatten(prefix string, obj map[string]interface{}, values map[string]string) {
+	for k, v := range obj {
+		switch nested := v.(type) {
+		case map[string]interface{}:
+			flatten(prefix+k+".", nested, values)
+			continue
+		case nil:
+			continue
+		}
+		values[prefix+k] = fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// parseYAML Parse the YAML subset used by config files: "key: value"
+// lines, nested by indentation, comments and quoted values.
+// Lists and multi-line values are not supported.
+// Nested keys are flattened, e.g. database.host.
+func parseYAML(data []byte) (map[string]string, error) {
+	type section struct {
+		indent int
+		key    string
+	}
+	var sections []section
+	values := make(map[string]string)
+
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(stripComment(line), " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		indent := len(line) - len(trimmed)
+
+		i := strings.Index(trimmed, ":")
+		if i <= 0 || strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("%s: line %d", c.ErrConfigFormat, n+1)
+		}
+		key := strings.TrimSpace(trimmed[:i])
+		value := strings.TrimSpace(trimmed[i+1:])
+
+		for len(sections) > 0 && sections[len(sections)-1].indent >= indent {
+			sections = sections[:len(sections)-1]
+		}
+		full := key
+		if len(sections) > 0 {
+			full = sections[len(sections)-1].key + "." + key
+		}
+
+		if value == "" {
+			sections = append(sections, section{indent: indent, key: full})
+			continue
+		}
+		values[full] = unquote(value)
+	}
+	return values, nil
+}
+
+// stripComment Remove a comment, unless '#' is quoted.
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquote(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
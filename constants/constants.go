@@ -5,6 +5,39 @@ import "time"
 const (
 	// AcceptedExt File extension accepted
 	AcceptedExt = ".csv"
+	// GzipExt Gzip compressed file extension
+	GzipExt = ".gz"
+	// Bzip2Ext Bzip2 compressed file extension
+	Bzip2Ext = ".bz2"
+	// ZstdExt Zstandard compressed file extension
+	ZstdExt = ".zst"
+	// SniffSize Bytes read to auto detect a CSV dialect
+	SniffSize = 4 * 1024
+
+	// EncodingAuto Detect the file encoding
+	EncodingAuto = "auto"
+	// EncodingUTF8 UTF-8 encoding
+	EncodingUTF8 = "utf-8"
+	// EncodingUTF16LE UTF-16 Little Endian encoding
+	EncodingUTF16LE = "utf-16le"
+	// EncodingUTF16BE UTF-16 Big Endian encoding
+	EncodingUTF16BE = "utf-16be"
+	// EncodingISO88591 ISO-8859-1 (Latin-1) encoding
+	EncodingISO88591 = "iso-8859-1"
+	// EncodingWindows1252 Windows-1252 encoding
+	EncodingWindows1252 = "windows-1252"
+
+	// ShardRoundRobin Rows are sent to every worker in turn
+	ShardRoundRobin = "round-robin"
+	// ShardLeastLoaded Rows are sent to the worker with fewer rows waiting
+	ShardLeastLoaded = "least-loaded"
+	// ShardHash Rows with the same key are sent to the same worker
+	ShardHash = "hash"
+
+	// CSVReaderEnv Prefix of csvreader's environment variables
+	CSVReaderEnv = "CSVREADER"
+	// CRMIntegratorEnv Prefix of crmintegrator's environment variables
+	CRMIntegratorEnv = "CRMINTEGRATOR"
 
 	//Workers Number of go routines concurrently
 	Workers = 30
@@ -15,8 +48,94 @@ const (
 
 	//CRMUrl CRM Json Appi's Url. This is a example server
 	CRMUrl = "https://jsonplaceholder.typicode.com/posts"
-	//CRMUrlFAIL CRM Json Appi's Url. This is a example server FAILING
-	CRMUrlFail = "https://jsonplaceholder.typicode.com/posts/FAIL"
+	// CRMMethod Http method used to send a row
+	CRMMethod = "POST"
+	// CRMBatchSize Rows sent at once. 1 sends a row per request
+	CRMBatchSize = 1
+	// CRMBurst Requests made at once after an idle time, if the rate is limited
+	CRMBurst = 1
+	// RateMin Floor of an adaptive rate, as a fraction of its max
+	RateMin = 0.01
+	// RateIncrease Requests per second added by a success, as a fraction
+	// of the max rate
+	RateIncrease = 0.05
+	// RateDecrease The rate is multiplied by it when the CRM is overloaded
+	RateDecrease = 0.5
+	// BreakerFailures Outages in a row which open the circuit breaker
+	BreakerFailures = 5
+	// BreakerCooldown Time the circuit breaker stays open before a probe
+	BreakerCooldown = 30 * time.Second
+	// BreakerPoll How often the integrator checks a half-open breaker
+	BreakerPoll = time.Second
+	// AuthBearer CRM auth by a static bearer token
+	AuthBearer = "bearer"
+	// AuthAPIKey CRM auth by an API key into a header
+	AuthAPIKey = "api_key"
+	// AuthBasic CRM auth by Http basic authentication
+	AuthBasic = "basic"
+	// AuthOAuth2 CRM auth by OAuth2 client credentials
+	AuthOAuth2 = "oauth2"
+	// AuthHeader Header which carries an API key
+	AuthHeader = "X-API-Key"
+	// TokenRefresh An OAuth2 token is fetched again this long before it expires
+	TokenRefresh = time.Minute
+	// SecretFile Prefix of a secret read from a file
+	SecretFile = "file:"
+	// SecretEnv Prefix of a secret read from an environment variable
+	SecretEnv = "env:"
+
+	// MappingString Type of a mapped field
+	MappingString = "string"
+	// MappingInt Type of a mapped field
+	MappingInt = "int"
+	// MappingFloat Type of a mapped field
+	MappingFloat = "float"
+	// MappingBool Type of a mapped field
+	MappingBool = "bool"
+	// MappingJSON Type of a mapped field, whose value is raw JSON
+	MappingJSON = "json"
+	// IdempotencyHeader Header which carries the idempotency key of a row
+	IdempotencyHeader = "Idempotency-Key"
+	// IdempotencyByID The idempotency key is made of the table name, row id
+	// & row version
+	IdempotencyByID = "id"
+	// IdempotencyVersionSize Bytes of the content hash which version a row
+	// in an idempotency key by id
+	IdempotencyVersionSize = 4
+	// IdempotencyByHash The idempotency key is a hash of the table name &
+	// row content
+	IdempotencyByHash = "hash"
+	// RetryMin Delay before a row is sent again after its first failure
+	RetryMin = 10 * time.Second
+	// RetryMax Max delay before a row is sent again
+	RetryMax = 5 * time.Minute
+	// RetryFactor The delay is multiplied by it after every failure
+	RetryFactor = 2
+
+	// LeaseTime How long rows claimed by an integrator are its own. It
+	// should be enough to send a whole batch
+	LeaseTime = 5 * time.Minute
+
+	// IPCTable Message by which csvreader announces the table it imports
+	IPCTable = "table"
+	// IPCRows Message by which csvreader announces rows it has inserted
+	IPCRows = "rows"
+	// IPCEOF Message by which csvreader announces the whole file has been
+	// inserted
+	IPCEOF = "eof"
+	// IPCProgress Message by which the integrator reports rows sent
+	IPCProgress = "progress"
+	// IPCDone Message by which the integrator reports every row of the
+	// file has been sent
+	IPCDone = "done"
+	// IPCMaxMessage Max bytes of an IPC message
+	IPCMaxMessage = 1 << 20
+	// IPCWriteTimeout Max time to write an IPC message, so a peer which
+	// doesn't read cannot block the other one
+	IPCWriteTimeout = 5 * time.Second
+
+	// ResponseSnippet Bytes of a CRM response saved when a row fails
+	ResponseSnippet = 1024
 	//TimeOut to Http requests
 	TimeOut = time.Duration(3 * time.Second)
 )
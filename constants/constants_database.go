@@ -1,5 +1,7 @@
 package constants
 
+import "time"
+
 const (
 	// DbDriver Database Driver
 	DbDriver = "postgres"
@@ -12,6 +14,76 @@ const (
 	//DbPass Database Password
 	DbPass = "postgres"
 
+	// InsertBatchRows Rows inserted at once by a csvreader worker through COPY.
+	// 1 inserts row by row.
+	InsertBatchRows = 500
+	// StagingSuffix Staging table used by COPY is named after its table.
+	StagingSuffix = "_staging"
+	// StagingOrd Staging table's column with the row order
+	StagingOrd = "staging_ord"
+
+	// ConflictSkip A row whose key already exists is skipped
+	ConflictSkip = "skip"
+	// ConflictOverwrite A row whose key already exists is overwritten and
+	// sent again to the CRM
+	ConflictOverwrite = "overwrite"
+	// ConflictFail A row whose key already exists stops the import
+	ConflictFail = "fail"
+
+	// SampleRows Rows read to infer the columns types
+	SampleRows = 1000
+
+	// TypeText SQL type for text columns
+	TypeText = "TEXT"
+	// TypeInteger SQL type for 32 bits integer columns
+	TypeInteger = "INTEGER"
+	// TypeBigInt SQL type for 64 bits integer columns
+	TypeBigInt = "BIGINT"
+	// TypeNumeric SQL type for decimal columns
+	TypeNumeric = "NUMERIC"
+	// TypeBoolean SQL type for boolean columns
+	TypeBoolean = "BOOLEAN"
+	// TypeDate SQL type for date columns
+	TypeDate = "DATE"
+	// TypeTimestamp SQL type for date & time columns
+	TypeTimestamp = "TIMESTAMP"
+
+	// CheckpointTable Table where csvreader saves how far an import went
+	CheckpointTable = "import_checkpoints"
+	// CheckpointRows Rows inserted between two checkpoints
+	CheckpointRows = 1000
+
+	// ColumnsTable Table where csvreader saves the original header of
+	// every column
+	ColumnsTable = "import_columns"
+	// ImportsTable Registry where csvreader saves every import, which is
+	// watched by the integrator daemon
+	ImportsTable = "imports"
+	// ImportImporting Status of an import being inserted by csvreader
+	ImportImporting = "importing"
+	// ImportImported Status of an import whose file has been inserted
+	ImportImported = "imported"
+	// ImportFailed Status of an import stopped by a failure. It could be
+	// resumed
+	ImportFailed = "failed"
+	// ImportDelivered Status of an import whose rows have been sent to
+	// the CRM, or set as dead letters
+	ImportDelivered = "delivered"
+	// ImportWeight Batches claimed from a table every round, by default
+	ImportWeight = 1
+	// RegistryPoll How often the integrator daemon reads the registry
+	RegistryPoll = 10 * time.Second
+	// DeadLetterTable Table where crmintegrator saves rows which will not
+	// be sent again
+	DeadLetterTable = "dead_letters"
+	// MaxIdentifierLen Postgres truncates longer identifiers
+	MaxIdentifierLen = 63
+
+	// ListenMinReconnect & ListenMaxReconnect Delays before the
+	// integrator's listener reconnects
+	ListenMinReconnect = 10 * time.Second
+	ListenMaxReconnect = time.Minute
+
 	// TotalRetry Number of time before skip a row
 	TotalRetry = 3
 
@@ -21,4 +93,8 @@ const (
 	IsProcessedPos = 1
 	//RetryPos Position into DB
 	RetryPos = 2
+	// IdempotencyKeyPos Position into DB
+	IdempotencyKeyPos = 3
+	// DataPos Position into DB of the first data column
+	DataPos = 4
 )
@@ -9,4 +9,37 @@ const (
 	ErrColumnNotFound   = "Column not found"
 	ErrGotSignal        = "Got Signal"
 	ErrFailureWorker    = "Failure in Worker"
+	ErrFileDelivered    = "File has been delivered"
+
+	ErrCompressionUnknown     = "Unknown compression format"
+	ErrCompressionUnsupported = "Compression format not supported"
+	ErrDelimiterInvalid       = "Invalid delimiter"
+	ErrDialectInvalid         = "Invalid CSV dialect"
+	ErrEncodingUnknown        = "Unknown encoding"
+	ErrResume                 = "Cannot resume the import"
+	ErrShardingUnknown        = "Unknown sharding strategy"
+	ErrShardingKey            = "Hash sharding needs a key"
+	ErrSchemaType             = "Unknown column type"
+	ErrConversion             = "Cannot convert value"
+	ErrConflictUnknown        = "Unknown conflict policy"
+	ErrConflictKey            = "Overwrite conflict policy needs a key"
+	ErrTableName              = "Table name has neither letters nor digits"
+	ErrConfigInvalid          = "Invalid configuration"
+	ErrConfigFormat           = "Unknown configuration file format"
+	ErrConfigKey              = "Unknown configuration key"
+	ErrCRMHeader              = "Invalid CRM header"
+	ErrCRMChaos               = "CRM request failed on purpose"
+	ErrCRMCircuitOpen         = "CRM circuit breaker is open"
+	ErrCRMAuthUnknown         = "Unknown CRM auth"
+	ErrCRMAuthToken           = "Cannot get a CRM access token"
+	ErrSecret                 = "Cannot read a secret"
+	ErrCRMBatchResults        = "Batch results not found into the CRM response"
+	ErrCRMBatchItem           = "No result for the row into the CRM response"
+	ErrCRMBatchStatus         = "Invalid row status into the CRM response"
+	ErrMapping                = "Invalid CRM mapping"
+	ErrMappingColumns         = "CRM mapping needs the columns names, which are saved by csvreader"
+	ErrIPCMessage             = "Invalid IPC message"
+	ErrIPCTooLarge            = "IPC message too large"
+	ErrDeadLetterNotFound     = "Dead letter not found"
+	ErrDeadLetterID           = "Invalid row id"
 )
@@ -4,6 +4,9 @@ const (
 	FileNameMock             = "testutils/file_mock_success"
 	FileNameMockEmpty        = "testutils/file_mock_empty"
 	FileNameMockErrorReading = "testutils/file_mock_error_reading"
+	FileNameMockGzipMagic    = "testutils/file_mock_gzip_magic"
+	FileNameMockSemicolon    = "testutils/file_mock_semicolon"
+	FileNameSchemaMock       = "testutils/schema_mock.json"
 	RunMode                  = "RUNMODE"
 	Test                     = "TEST"
 )
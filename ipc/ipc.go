@@ -0,0 +1,156 @@
+package ipc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	c "github.com/josesolana/csv-reader/constants"
+)
+
+// Message What csvreader & the integrator tell each other.
+// The database is the source of truth: messages only save them polling.
+type Message struct {
+	// Type table, rows or eof from csvreader. progress or done from the
+	// integrator.
+	Type  string `json:"type"`
+	Table string `json:"table"`
+	// From & To First & last file lines of a batch. Lines between them
+	// could belong to another batch, as rows are sharded between workers.
+	From int `json:"from,omitempty"`
+	To   int `json:"to,omitempty"`
+	// Rows Rows inserted: by a batch, or by the whole import at eof.
+	Rows int `json:"rows,omitempty"`
+	// Processed, DeadLetters & Pending Rows of the table by their state.
+	Processed   int `json:"processed,omitempty"`
+	DeadLetters int `json:"dead_letters,omitempty"`
+	Pending     int `json:"pending,omitempty"`
+}
+
+var errMessage = errors.New(c.ErrIPCMessage)
+var errTooLarge = errors.New(c.ErrIPCTooLarge)
+
+// Conn A connection between csvreader & the integrator.
+// Messages are JSON, prefixed by their length as 4 bytes big endian.
+// It can be used by several go routines at once.
+type Conn struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+	readMu  sync.Mutex
+}
+
+// NewConn Wrap a connection, e.g. one end of net.Pipe.
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{conn: conn}
+}
+
+// Dial Connect to the integrator listening at path.
+func Dial(path string) (*Conn, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn), nil
+}
+
+// Send Write a message. It fails after c.IPCWriteTimeout if the peer
+// doesn't read. The connection is closed on failure, as a message could
+// have been written in part.
+func (cn *Conn) Send(m Message) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if len(body) > c.IPCMaxMessage {
+		return errTooLarge
+	}
+	buf := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(buf, uint32(len(body)))
+	copy(buf[4:], body)
+
+	cn.writeMu.Lock()
+	defer cn.writeMu.Unlock()
+	cn.conn.SetWriteDeadline(time.Now().Add(c.IPCWriteTimeout))
+	if _, err = cn.conn.Write(buf); err != nil {
+		cn.conn.Close()
+	}
+	return err
+}
+
+// Receive Read the next message. io.EOF once the peer has closed.
+func (cn *Conn) Receive() (Message, error) {
+	cn.readMu.Lock()
+	defer cn.readMu.Unlock()
+
+	var m Message
+	var size [4]byte
+	if _, err := io.ReadFull(cn.conn, size[:]); err != nil {
+		return m, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > c.IPCMaxMessage {
+		return m, errTooLarge
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(cn.conn, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return m, err
+	}
+	if err := json.Unmarshal(body, &m); err != nil || m.Type == "" {
+		log.Printf("Invalid IPC message: %s\n", body)
+		return m, errMessage
+	}
+	return m, nil
+}
+
+// Close Close the connection. Receive returns an error from then on.
+func (cn *Conn) Close() error {
+	return cn.conn.Close()
+}
+
+// Listener Where the integrator waits for csvreader.
+type Listener struct {
+	l    net.Listener
+	path string
+}
+
+// Listen Listen at path. A socket left there by a process which didn't
+// stop cleanly is removed first.
+func Listen(path string) (*Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+		} else if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{l: l, path: path}, nil
+}
+
+// Accept Wait for the next csvreader.
+func (l *Listener) Accept() (*Conn, error) {
+	conn, err := l.l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn), nil
+}
+
+// Close Stop listening & remove the socket.
+func (l *Listener) Close() error {
+	err := l.l.Close()
+	os.Remove(l.path)
+	return err
+}
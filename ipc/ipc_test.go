@@ -0,0 +1,104 @@
+package ipc
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	c "github.com/josesolana/csv-reader/constants"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type IPCTest struct {
+	suite.Suite
+	dir string
+}
+
+func TestIPC(t *testing.T) {
+	suite.Run(t, new(IPCTest))
+}
+
+func (it *IPCTest) SetupTest() {
+	dir, err := ioutil.TempDir("", "ipc")
+	it.Nil(err)
+	it.dir = dir
+}
+
+func (it *IPCTest) TearDownTest() {
+	os.RemoveAll(it.dir)
+}
+
+func (it *IPCTest) TestSendReceive() {
+	path := filepath.Join(it.dir, "csvreader.sock")
+	l, err := Listen(path)
+	it.Nil(err)
+	defer l.Close()
+
+	accepted := make(chan *Conn)
+	go func() {
+		conn, err := l.Accept()
+		it.Nil(err)
+		accepted <- conn
+	}()
+
+	reader, err := Dial(path)
+	it.Nil(err)
+	integrator := <-accepted
+
+	it.Nil(reader.Send(Message{Type: c.IPCTable, Table: "customers"}))
+	it.Nil(reader.Send(Message{Type: c.IPCRows, Table: "customers", From: 2, To: 501, Rows: 500}))
+	m, err := integrator.Receive()
+	it.Nil(err)
+	it.Equal(Message{Type: c.IPCTable, Table: "customers"}, m)
+	m, err = integrator.Receive()
+	it.Nil(err)
+	it.Equal(Message{Type: c.IPCRows, Table: "customers", From: 2, To: 501, Rows: 500}, m)
+
+	it.Nil(integrator.Send(Message{Type: c.IPCDone, Table: "customers", Processed: 499, DeadLetters: 1}))
+	m, err = reader.Receive()
+	it.Nil(err)
+	it.Equal(499, m.Processed)
+	it.Equal(1, m.DeadLetters)
+
+	reader.Close()
+	_, err = integrator.Receive()
+	it.Equal(io.EOF, err)
+	integrator.Close()
+}
+
+func (it *IPCTest) TestStaleSocket() {
+	path := filepath.Join(it.dir, "csvreader.sock")
+	l, err := net.Listen("unix", path)
+	it.Nil(err)
+	// Left behind, as by a process which was killed.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	ipcL, err := Listen(path)
+	it.Nil(err)
+	ipcL.Close()
+	_, err = os.Stat(path)
+	it.True(os.IsNotExist(err))
+}
+
+func (it *IPCTest) TestInvalid() {
+	a, b := net.Pipe()
+	reader, integrator := NewConn(a), NewConn(b)
+	defer reader.Close()
+	defer integrator.Close()
+
+	go a.Write([]byte{0, 0, 0, 2, '{', '}'})
+	_, err := integrator.Receive()
+	it.EqualError(err, c.ErrIPCMessage)
+
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], c.IPCMaxMessage+1)
+	go a.Write(size[:])
+	_, err = integrator.Receive()
+	it.EqualError(err, c.ErrIPCTooLarge)
+}